@@ -5,61 +5,316 @@
 package q
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/printer"
 	"go/token"
+	"os"
+	"reflect"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode/utf8"
-
-	"github.com/kr/pretty"
 )
 
 // argName returns the source text of the given argument if it's a variable or
 // an expression. If the argument is something else, like a literal, argName
-// returns an empty string.
+// returns an empty string. Literals that print differently from how they're
+// written, like 0x1f or 'a', are named, and long literals are abbreviated, e.g.
+// []int{…} and func(n int) bool {…}.
+// nolint: cyclop
 func argName(arg ast.Expr) string {
 	name := ""
 
 	switch a := arg.(type) {
 	case *ast.Ident:
-		switch {
-		case a.Obj == nil:
+		// Identifiers are named whether they're declared in the same
+		// function, in another file, or in another package, so that every
+		// variable and constant is named the same way. Only the predeclared
+		// constants are left out, since they'd print as e.g. true=true.
+		switch a.Name {
+		case "true", "false", "nil":
+		default:
 			name = a.Name
-		case a.Obj.Kind == ast.Var, a.Obj.Kind == ast.Con:
-			name = a.Obj.Name
+		}
+	case *ast.BasicLit:
+		if !isPlainLiteral(a) {
+			name = a.Value
+		}
+	case *ast.CompositeLit:
+		name = typeLabel(a.Type) + "{}"
+		if len(a.Elts) > 0 {
+			name = typeLabel(a.Type) + "{…}"
+		}
+	case *ast.FuncLit:
+		name = typeLabel(a.Type) + " {…}"
+	case *ast.UnaryExpr:
+		switch a.X.(type) {
+		case *ast.CompositeLit, *ast.FuncLit:
+			// &T{…}
+			name = a.Op.String() + argName(a.X)
+		default:
+			name = exprToString(arg)
 		}
 	case *ast.BinaryExpr,
 		*ast.CallExpr,
 		*ast.IndexExpr,
+		*ast.IndexListExpr,
 		*ast.KeyValueExpr,
 		*ast.ParenExpr,
 		*ast.SelectorExpr,
 		*ast.SliceExpr,
-		*ast.TypeAssertExpr,
-		*ast.UnaryExpr:
+		*ast.StarExpr,
+		*ast.TypeAssertExpr:
 		name = exprToString(arg)
 	}
 
 	return name
 }
 
-// argNames finds the q.Q() call at the given filename/line number and
-// returns its arguments as a slice of strings. If the argument is a literal,
-// argNames will return an empty string at the index position of that argument.
-// For example, q.Q(ip, port, 5432) would return []string{"ip", "port", ""}.
-// argNames returns an error if the source text cannot be parsed.
-func argNames(filename string, line int) ([]string, error) {
+// isPlainLiteral returns true if the given literal is printed the same way
+// it's written, so naming it would be redundant, e.g. 42 or "hello". 0x2a and
+// 'a' aren't plain, because they're printed as 42 and 97.
+func isPlainLiteral(lit *ast.BasicLit) bool {
+	switch lit.Kind {
+	case token.INT:
+		return strings.TrimLeft(lit.Value, "0123456789") == "" && (lit.Value == "0" || lit.Value[0] != '0')
+	case token.FLOAT:
+		return !strings.ContainsAny(lit.Value, "eEpPxX_")
+	case token.STRING:
+		return true
+	}
+
+	return false
+}
+
+// typeLabel returns the source text of the given type, for labeling a literal
+// of that type. Types that span several lines, like struct{ … }, are collapsed
+// to their first line, e.g. []struct{…}. Literals whose type is implied by the
+// enclosing literal have no type, so their label is empty.
+func typeLabel(typ ast.Expr) string {
+	if typ == nil {
+		return ""
+	}
+
+	label := exprToString(typ)
+	if i := strings.IndexByte(label, '{'); i >= 0 && strings.Contains(label, "\n") {
+		label = strings.TrimRight(label[:i], " ") + "{…}"
+	}
+
+	return label
+}
+
+// caller describes a call to a q function from user code.
+type caller struct {
+	funcName string  // the calling function, e.g. main.main
+	file     string  // the source file containing the call
+	line     int     // the line of the call, as reported by runtime.Caller()
+	pc       uintptr // the return address of the call. unique to the call site
+	qFunc    string  // the q function that was called, e.g. Q or V
+}
+
+// fileLine is a line in the source text that contains q.Q() calls.
+type fileLine struct {
+	file string
+	line int
+}
+
+// cachedCalls are the q.Q() calls found at a call site, and the version of the
+// source file they were found in.
+type cachedCalls struct {
+	modTime time.Time
+	size    int64
+	calls   []CallSite
+}
+
+// pcKey identifies all calls to the same q function on the same line.
+type pcKey struct {
+	site  fileLine
+	qFunc string
+}
+
+// nameCache caches the q.Q() calls found at each call site, so that q.Q()
+// calls in hot loops don't parse the whole source file every time. It also
+// remembers the return addresses of the calls, to tell apart multiple calls on
+// the same line. It is safe for concurrent use.
+type nameCache struct {
+	mu    sync.Mutex
+	sites map[fileLine]cachedCalls
+	pcs   map[pcKey][]uintptr // sorted return addresses seen for each line
+}
+
+// nolint: gochecknoglobals
+var argNamesCache nameCache
+
+// get returns the cached calls for the given call site, if the source file
+// hasn't changed since they were cached.
+func (c *nameCache) get(site fileLine, info os.FileInfo) ([]CallSite, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.sites[site]
+	if !ok || !cached.modTime.Equal(info.ModTime()) || cached.size != info.Size() {
+		return nil, false
+	}
+
+	return cached.calls, true
+}
+
+// put caches the calls found at the given call site.
+func (c *nameCache) put(site fileLine, info os.FileInfo, calls []CallSite) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sites == nil {
+		c.sites = map[fileLine]cachedCalls{}
+	}
+	c.sites[site] = cachedCalls{modTime: info.ModTime(), size: info.Size(), calls: calls}
+}
+
+// rank returns the index of the given caller among n calls to the same q
+// function on the same line. The calls are assumed to be compiled in the order
+// they're evaluated, so the call with the lowest return address is the first
+// one. The rank is only certain once the return addresses of all n calls have
+// been seen, so rank also returns whether it's sure.
+func (c *nameCache) rank(caller caller, n int) (rank int, sure bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := pcKey{site: fileLine{file: caller.file, line: caller.line}, qFunc: caller.qFunc}
+	pcs := c.pcs[key]
+	rank, found := slices.BinarySearch(pcs, caller.pc)
+	if !found {
+		pcs = slices.Insert(pcs, rank, caller.pc)
+		if c.pcs == nil {
+			c.pcs = map[pcKey][]uintptr{}
+		}
+		c.pcs[key] = pcs
+	}
+
+	if rank >= n {
+		// There are more return addresses than calls. Maybe the compiler
+		// duplicated some code.
+		return n - 1, false
+	}
+
+	return rank, len(pcs) == n
+}
+
+// argNames finds the q.Q() call made by the given caller and returns its
+// arguments as a slice of strings. If the argument is a literal, argNames will
+// return an empty string at the index position of that argument. For example,
+// q.Q(ip, port, 5432) would return []string{"ip", "port", ""}. argNames returns
+// an error if the source text cannot be parsed.
+//
+// If there are several calls to the same q function on the same line, e.g.
+// q.Q(a); q.Q(b), the caller's return address is used to pick the right one.
+// Until that's certain, the names are marked with a trailing "?".
+//
+// Call sites registered by code generated by qgen are used if there are any for
+// the caller's file. Otherwise, the calls found in the source text are cached
+// until the source file is modified. The returned slice may be shared with the
+// cache, so it must not be modified.
+func argNames(c caller) ([]string, error) {
+	calls, registered := registeredCallSites.lookup(c.file, c.line)
+	if !registered {
+		var err error
+		calls, err = cachedCallsOnLine(sources.locate(c.file), c.line)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.qFunc != "" {
+		calls = slices.DeleteFunc(slices.Clone(calls), func(call CallSite) bool {
+			return call.Func != c.qFunc
+		})
+	}
+
+	calls = closestCalls(calls, c.line)
+	switch len(calls) {
+	case 0:
+		return nil, nil
+	case 1:
+		return calls[0].Args, nil
+	}
+
+	rank, sure := argNamesCache.rank(c, len(calls))
+	if sure {
+		return calls[rank].Args, nil
+	}
+
+	return uncertainNames(calls[rank].Args), nil
+}
+
+// cachedCallsOnLine returns the q.Q() calls that span the given line of the
+// given source file. They're parsed from the source text the first time, and
+// cached until the file is modified.
+func cachedCallsOnLine(filename string, line int) ([]CallSite, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %q: %w", filename, err)
+	}
+
+	site := fileLine{file: filename, line: line}
+	calls, ok := argNamesCache.get(site, info)
+	if !ok {
+		calls, err = parseCalls(filename, line)
+		if err != nil {
+			return nil, err
+		}
+		argNamesCache.put(site, info, calls)
+	}
+
+	return calls, nil
+}
+
+// uncertainNames marks each name with a trailing "?", to show that it may
+// belong to a different call on the same line.
+func uncertainNames(names []string) []string {
+	marked := make([]string, len(names))
+	for i, name := range names {
+		if name != "" {
+			marked[i] = name + "?"
+		}
+	}
+
+	return marked
+}
+
+// parseCalls parses the given source file and returns the q.Q() calls that span
+// the given line, in the order they're evaluated.
+func parseCalls(filename string, line int) ([]CallSite, error) {
+	calls, err := parseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return callsOnLine(calls, line), nil
+}
+
+// parseFile parses the given source file and returns all the q.Q() calls in it.
+// Calls on the same line are in the order they're evaluated.
+func parseFile(filename string) ([]CallSite, error) {
 	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, filename, nil, 0)
+	f, err := parser.ParseFile(fset, filename, nil, parser.SkipObjectResolution)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse %q: %w", filename, err)
 	}
 
-	var names []string
+	imports := qImportsOf(f)
+	if len(imports.names) == 0 && !imports.unqualified {
+		// The file doesn't import the q package, so it has no q calls.
+		return nil, nil
+	}
+
+	var calls []*ast.CallExpr
 	ast.Inspect(f, func(n ast.Node) bool {
 		call, is := n.(*ast.CallExpr)
 		if !is {
@@ -67,25 +322,98 @@ func argNames(filename string, line int) ([]string, error) {
 			return true // visit next node
 		}
 
-		if fset.Position(call.End()).Line != line {
-			// The node is a function call, but it's on the wrong line.
+		if !isQCall(call, imports) {
+			// The node is a function call, but it's not a Q() function.
 			return true
 		}
 
-		if !isQCall(call) {
-			// The node is a function call on correct line, but it's not a Q()
-			// function.
-			return true
-		}
+		calls = append(calls, call)
+
+		return true
+	})
+
+	// Nested calls are evaluated before the calls containing them, and
+	// sequential calls are evaluated left to right. So, calls are evaluated in
+	// the order they end.
+	slices.SortFunc(calls, func(a, b *ast.CallExpr) int {
+		return cmp.Compare(a.End(), b.End())
+	})
 
+	sites := make([]CallSite, 0, len(calls))
+	for _, call := range calls {
+		site := CallSite{
+			Func:       calledFunc(call),
+			StartLine:  fset.Position(call.Pos()).Line,
+			LparenLine: fset.Position(call.Lparen).Line,
+			RparenLine: fset.Position(call.Rparen).Line,
+		}
 		for _, arg := range call.Args {
-			names = append(names, argName(arg))
+			site.Args = append(site.Args, argName(arg))
+		}
+		if call.Ellipsis.IsValid() {
+			// q.Q(args...) passes a slice of values. We can't tell how many
+			// there are, so the names can't be matched to the values.
+			site.Args = nil
 		}
+		sites = append(sites, site)
+	}
 
-		return true
-	})
+	return sites, nil
+}
+
+// callsOnLine returns the calls that span the given line.
+func callsOnLine(calls []CallSite, line int) []CallSite {
+	var onLine []CallSite
+	for _, call := range calls {
+		if call.StartLine <= line && line <= call.RparenLine {
+			onLine = append(onLine, call)
+		}
+	}
+
+	return onLine
+}
+
+// closestCalls narrows down the q.Q() calls spanning the given line to the ones
+// that were most likely reported at that line by runtime.Caller(). Multi-line
+// calls are reported at the line of the opening paren by current compilers, and
+// at the line of the closing paren by older ones. If neither is on the given
+// line, the innermost call spanning the line is returned. The calls must be in
+// evaluation order.
+func closestCalls(calls []CallSite, line int) []CallSite {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	for _, parenLine := range []func(CallSite) int{
+		func(c CallSite) int { return c.LparenLine },
+		func(c CallSite) int { return c.RparenLine },
+	} {
+		var onLine []CallSite
+		for _, call := range calls {
+			if parenLine(call) == line {
+				onLine = append(onLine, call)
+			}
+		}
+		if len(onLine) > 0 {
+			return onLine
+		}
+	}
 
-	return names, nil
+	// Nested calls are evaluated first, so the innermost call comes first.
+	return calls[:1]
+}
+
+// calledFunc returns the name of the function called by the given call
+// expression, e.g. "Q" for q.Q(a).
+func calledFunc(call *ast.CallExpr) string {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return fun.Name
+	case *ast.SelectorExpr:
+		return fun.Sel.Name
+	}
+
+	return ""
 }
 
 // argWidth returns the number of characters that will be seen when the given
@@ -99,6 +427,8 @@ func argWidth(arg string) int {
 		"\f", "",
 		"\v", "",
 		string(bold), "",
+		string(red), "",
+		string(green), "",
 		string(yellow), "",
 		string(cyan), "",
 		string(endColor), "",
@@ -129,9 +459,15 @@ func exprToString(arg ast.Expr) string {
 
 // formatArgs converts the given args to pretty-printed, colorized strings.
 func formatArgs(args ...any) []string {
+	return formatArgsWith(format, args)
+}
+
+// formatArgsWith converts the given args to colorized strings with the given
+// format function.
+func formatArgsWith(format func(any) string, args []any) []string {
 	formatted := make([]string, 0, len(args))
 	for _, a := range args {
-		s := colorize(pretty.Sprint(a), cyan)
+		s := colorize(format(a), cyan)
 		formatted = append(formatted, s)
 	}
 
@@ -139,20 +475,38 @@ func formatArgs(args ...any) []string {
 }
 
 // getCallerInfo returns the name, file, and line number of the function calling
-// q.Q().
-func getCallerInfo() (funcName, file string, line int, err error) {
+// q.Q(), and which q function it called.
+func getCallerInfo() (caller, error) {
 	pc, file, line, ok := runtime.Caller(CallDepth)
 	if !ok {
 		// This error is not exported. It is only used internally in the q
 		// package. The error message isn't even used by the caller. So, I've
 		// suppressed the err113 linter here, which catches nonidiomatic
 		// error handling post Go 1.13 errors.
-		return "", "", 0, errors.New("failed to get info about the function calling q.Q") // nolint: err113
+		return caller{}, errors.New("failed to get info about the function calling q.Q") // nolint: err113
+	}
+
+	c := caller{
+		funcName: runtime.FuncForPC(pc).Name(),
+		file:     file,
+		line:     line,
+		pc:       pc,
 	}
 
-	funcName = runtime.FuncForPC(pc).Name()
+	// The q function is one frame below the caller.
+	if qpc, _, _, ok := runtime.Caller(CallDepth - 1); ok {
+		c.qFunc = qFuncName(runtime.FuncForPC(qpc).Name())
+	}
 
-	return funcName, file, line, nil
+	return c, nil
+}
+
+// qFuncName returns the unqualified name of the given function, e.g.
+// "github.com/ryboe/q.V[...]" becomes "V".
+func qFuncName(funcName string) string {
+	funcName = strings.TrimSuffix(funcName, "[...]")
+
+	return funcName[strings.LastIndexByte(funcName, '.')+1:]
 }
 
 // prependArgName turns argument names and values into name=value strings, e.g.
@@ -170,32 +524,124 @@ func prependArgName(names, values []string) []string {
 
 			continue
 		}
-		name = colorize(name, bold)
+		name = colorize(truncateName(name), bold)
 		prepended[i] = fmt.Sprintf("%s=%s", name, value)
 	}
 
 	return prepended
 }
 
-// isQCall returns true if the given function call expression is Q() or q.Q().
-func isQCall(n *ast.CallExpr) bool {
-	return isQFunction(n) || isQPackage(n)
+// typesOf returns the dynamic type of each of the given values. The type of a
+// nil interface is nil.
+func typesOf(v []any) []reflect.Type {
+	types := make([]reflect.Type, len(v))
+	for i, value := range v {
+		value, _ = unwrapSnapshot(value)
+		types[i] = reflect.TypeOf(value)
+	}
+
+	return types
+}
+
+// withTypes appends the type of each value to its name if ShowTypes is set,
+// e.g. "port (uint16)". Values without a name are labeled with just their type,
+// e.g. "(int)". Names of values without a type, like nil, are left as they are.
+func withTypes(names []string, types []reflect.Type) []string {
+	if !ShowTypes {
+		return names
+	}
+
+	labeled := make([]string, max(len(names), len(types)))
+	copy(labeled, names)
+	for i, t := range types {
+		switch {
+		case t == nil:
+		case labeled[i] == "":
+			labeled[i] = "(" + t.String() + ")"
+		default:
+			labeled[i] += " (" + t.String() + ")"
+		}
+	}
+
+	return labeled
+}
+
+// qImports describes how a source file imports the q package.
+type qImports struct {
+	names       []string // the names the package is imported as, e.g. "q" or "dbg"
+	unqualified bool     // true if q functions can be called without a package name
+}
+
+// qImportsOf returns how the given file imports the q package. Files in the q
+// package itself, and files that dot-import it, can call q functions without a
+// package name.
+func qImportsOf(f *ast.File) qImports {
+	imports := qImports{unqualified: f.Name.Name == "q"}
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || path != qImportPath() {
+			continue
+		}
+
+		switch {
+		case spec.Name == nil:
+			imports.names = append(imports.names, "q")
+		case spec.Name.Name == ".":
+			imports.unqualified = true
+		case spec.Name.Name != "_":
+			imports.names = append(imports.names, spec.Name.Name)
+		}
+	}
+
+	return imports
+}
+
+// qPackagePath returns the path of the q package, as it appears in function
+// names, e.g. "github.com/ryboe/q".
+func qPackagePath() string {
+	return reflect.TypeFor[option]().PkgPath()
 }
 
-// isQFunction returns true if the given function call expression is Q().
+// qImportPath returns the path that source files use to import the q package.
+// It's the same as qPackagePath(), unless q is vendored in GOPATH mode.
+func qImportPath() string {
+	path := qPackagePath()
+	if i := strings.LastIndex(path, "/vendor/"); i >= 0 {
+		path = path[i+len("/vendor/"):]
+	}
+
+	return path
+}
+
+// isQCall returns true if the given function call expression is Q() or q.Q(),
+// or one of the other q functions, like q.V() and q.Qf(). imports describes
+// how the file containing the call imports the q package.
+func isQCall(n *ast.CallExpr, imports qImports) bool {
+	return (imports.unqualified && isQFunction(n)) || isQPackage(n, imports)
+}
+
+// isQFunction returns true if the given function call expression is Q(), Qf(),
+// D(), Watch(), Trace(), V(), V2(), or V3().
 func isQFunction(n *ast.CallExpr) bool {
 	ident, is := n.Fun.(*ast.Ident)
 	if !is {
 		return false
 	}
 
-	return ident.Name == "Q"
+	switch ident.Name {
+	case "Q", "Qf", "D", "Watch", "Trace", "V", "V2", "V3":
+		return true
+	}
+
+	return false
 }
 
 // isQPackage returns true if the given function call expression is in the q
-// package. Since Q() is the only exported function from the q package, this is
-// sufficient for determining that we've found Q() in the source text.
-func isQPackage(n *ast.CallExpr) bool {
+// package, given the names it's imported as. Not every function in the q
+// package logs its arguments, e.g. q.Flush() and q.Redact() don't, so this
+// matches more calls than it should. argNames() drops the extra ones by keeping
+// only the calls to the q function that was actually called (c.qFunc).
+func isQPackage(n *ast.CallExpr, imports qImports) bool {
 	sel, is := n.Fun.(*ast.SelectorExpr) // SelectorExpr example: a.B()
 	if !is {
 		return false
@@ -206,5 +652,5 @@ func isQPackage(n *ast.CallExpr) bool {
 		return false
 	}
 
-	return ident.Name == "q"
+	return slices.Contains(imports.names, ident.Name)
 }
@@ -7,10 +7,13 @@ package q
 import (
 	"fmt"
 	"go/ast"
+	"go/parser"
 	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
-
-	"github.com/kr/pretty"
+	"time"
 )
 
 // TestExtractingArgsFromSourceText verifies that exprToString() and argName()
@@ -165,6 +168,28 @@ func TestExtractingArgsFromSourceText(t *testing.T) {
 			},
 			want: "string",
 		},
+		{
+			id: 17,
+			arg: &ast.IndexListExpr{
+				X: &ast.Ident{
+					Name: "Max",
+				},
+				Indices: []ast.Expr{
+					&ast.Ident{Name: "int"},
+					&ast.Ident{Name: "float64"},
+				},
+			},
+			want: "Max[int, float64]",
+		},
+		{
+			id: 18,
+			arg: &ast.StarExpr{
+				X: &ast.Ident{
+					Name: "p",
+				},
+			},
+			want: "*p",
+		},
 	}
 
 	// We can test both exprToString() and argName() with the test cases above.
@@ -193,13 +218,83 @@ func TestExtractingArgsFromSourceText(t *testing.T) {
 	}
 }
 
+// TestArgNameLabels verifies that argName() names literals that print
+// differently from how they're written, and abbreviates long literals.
+func TestArgNameLabels(t *testing.T) {
+	testCases := []struct {
+		arg  string
+		want string
+	}{
+		{arg: "nil", want: ""},
+		{arg: "true", want: ""},
+		{arg: "handler", want: "handler"},
+		{arg: "42", want: ""},
+		{arg: "0", want: ""},
+		{arg: "3.14", want: ""},
+		{arg: `"hello"`, want: ""},
+		{arg: "`raw`", want: ""},
+		{arg: "0x1f", want: "0x1f"},
+		{arg: "0755", want: "0755"},
+		{arg: "1_000_000", want: "1_000_000"},
+		{arg: "1e3", want: "1e3"},
+		{arg: "2i", want: "2i"},
+		{arg: "'a'", want: "'a'"},
+		{arg: "T{}", want: "T{}"},
+		{arg: "[]int{1, 2, 3}", want: "[]int{…}"},
+		{arg: "&Config{Port: 443}", want: "&Config{…}"},
+		{arg: "map[string]int{\"a\": 1}", want: "map[string]int{…}"},
+		{arg: "struct{ A int }{1}", want: "struct{ A int }{…}"},
+		{arg: "[]struct{ A int; B string }{{1, \"a\"}}", want: "[]struct{…}{…}"},
+		{arg: "func(n int) bool { return n > 0 }", want: "func(n int) bool {…}"},
+		{arg: "Max[int, float64](a, b)", want: "Max[int, float64](a, b)"},
+		{arg: "*p", want: "*p"},
+		{arg: "-x", want: "-x"},
+	}
+
+	for _, tc := range testCases {
+		expr, err := parser.ParseExpr(tc.arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := argName(expr); got != tc.want {
+			t.Fatalf("\nargName(%s)\ngot:  %q\nwant: %q", tc.arg, got, tc.want)
+		}
+	}
+}
+
+// TestWithTypes verifies that withTypes() labels every value that has a type.
+func TestWithTypes(t *testing.T) {
+	ShowTypes = true
+	t.Cleanup(func() { ShowTypes = false })
+
+	var err error
+	testCases := []struct {
+		names []string
+		v     []any
+		want  []string
+	}{
+		{names: []string{"port"}, v: []any{uint16(443)}, want: []string{"port (uint16)"}},
+		{names: []string{""}, v: []any{443}, want: []string{"(int)"}},
+		{names: nil, v: []any{"a", 1.5}, want: []string{"(string)", "(float64)"}},
+		{names: []string{"err"}, v: []any{err}, want: []string{"err"}},
+	}
+
+	for _, tc := range testCases {
+		got := withTypes(tc.names, typesOf(tc.v))
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("\nwithTypes(%q, %v)\ngot:  %q\nwant: %q", tc.names, tc.v, got, tc.want)
+		}
+	}
+}
+
 // TestArgNames verifies that argNames() is able to find the q.Q() call in the
 // sample text and extract the argument names. For example, if q.q(a, b, c) is
 // in the sample text, argNames() should return []string{"a", "b", "c"}.
 func TestArgNames(t *testing.T) {
 	const filename = "testdata/sample1.go"
 	want := []string{"a", "b", "c", "d", "e", "f", "g"}
-	got, err := argNames(filename, 14)
+	got, err := argNames(caller{file: filename, line: 14})
 	if err != nil {
 		t.Fatalf("argNames: failed to parse %q: %v", filename, err)
 	}
@@ -215,11 +310,153 @@ func TestArgNames(t *testing.T) {
 	}
 }
 
+// TestArgNamesMultiLine verifies that argNames() finds the names of multi-line
+// q.Q() calls, whichever line of the call is reported by runtime.Caller().
+func TestArgNamesMultiLine(t *testing.T) {
+	const filename = "testdata/sample2.go"
+	testCases := []struct {
+		line int
+		want []string
+	}{
+		// gofmt'd call with a trailing comma
+		{line: 8, want: []string{"a", "b"}},
+		{line: 9, want: []string{"a", "b"}},
+		{line: 11, want: []string{"a", "b"}},
+		// call ending on the line of the last arg
+		{line: 13, want: []string{"a", "b"}},
+		{line: 14, want: []string{"a", "b"}},
+		// multi-line call nested inside a multi-line call
+		{line: 16, want: []string{"foo(c)", "d"}},
+		{line: 18, want: []string{"foo(c)", "d"}},
+		// q call nested inside a multi-line q call
+		{line: 23, want: []string{"q.V(c)", "d"}},
+		{line: 24, want: []string{"c"}},
+		{line: 26, want: []string{"q.V(c)", "d"}},
+		// no q call on this line
+		{line: 12, want: nil},
+	}
+
+	for _, tc := range testCases {
+		got, err := argNames(caller{file: filename, line: tc.line})
+		if err != nil {
+			t.Fatalf("argNames: failed to parse %q: %v", filename, err)
+		}
+
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("\nargNames(%q, %d)\ngot:  %#v\nwant: %#v", filename, tc.line, got, tc.want)
+		}
+	}
+}
+
+// TestArgNamesSameLine verifies that argNames() picks the right call when there
+// are several q calls on the same line.
+func TestArgNamesSameLine(t *testing.T) {
+	const filename = "testdata/sample3.go"
+	testCases := []struct {
+		caller caller
+		want   []string
+	}{
+		// nested calls to different q functions
+		{caller: caller{file: filename, line: 9, qFunc: "Q", pc: 1}, want: []string{"q.V(a)"}},
+		{caller: caller{file: filename, line: 9, qFunc: "V", pc: 2}, want: []string{"a"}},
+		// sequential calls to the same q function. The second call runs first,
+		// so we can't be sure which call it is until the first call runs.
+		{caller: caller{file: filename, line: 10, qFunc: "V", pc: 200}, want: []string{"b?"}},
+		{caller: caller{file: filename, line: 10, qFunc: "V", pc: 100}, want: []string{"b"}},
+		{caller: caller{file: filename, line: 10, qFunc: "V", pc: 200}, want: []string{"c"}},
+		// the values in xs can't be matched to names
+		{caller: caller{file: filename, line: 11, qFunc: "Q", pc: 3}, want: nil},
+	}
+
+	for _, tc := range testCases {
+		got, err := argNames(tc.caller)
+		if err != nil {
+			t.Fatalf("argNames: failed to parse %q: %v", filename, err)
+		}
+
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("\nargNames(%+v)\ngot:  %#v\nwant: %#v", tc.caller, got, tc.want)
+		}
+	}
+}
+
+// TestQFuncName verifies that qFuncName() strips the package path and type
+// parameters from function names.
+func TestQFuncName(t *testing.T) {
+	testCases := []struct {
+		funcName string
+		want     string
+	}{
+		{funcName: "github.com/ryboe/q.Q", want: "Q"},
+		{funcName: "github.com/ryboe/q.V[...]", want: "V"},
+		{funcName: "main.debug", want: "debug"},
+	}
+
+	for _, tc := range testCases {
+		if got := qFuncName(tc.funcName); got != tc.want {
+			t.Fatalf("\nqFuncName(%q)\ngot:  %q\nwant: %q", tc.funcName, got, tc.want)
+		}
+	}
+}
+
+// TestArgNamesImports verifies that argNames() only finds calls to the q
+// package, however it's imported.
+func TestArgNamesImports(t *testing.T) {
+	testCases := []struct {
+		caller caller
+		want   []string
+	}{
+		// q imported as dbg. Q(b) is an unrelated function.
+		{caller: caller{file: "testdata/sample4.go", line: 9, qFunc: "Q"}, want: []string{"a", "Q(b)"}},
+		// q dot-imported
+		{caller: caller{file: "testdata/sample5.go", line: 7, qFunc: "Q"}, want: []string{"a"}},
+	}
+
+	for _, tc := range testCases {
+		got, err := argNames(tc.caller)
+		if err != nil {
+			t.Fatalf("argNames: failed to parse %q: %v", tc.caller.file, err)
+		}
+
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("\nargNames(%+v)\ngot:  %#v\nwant: %#v", tc.caller, got, tc.want)
+		}
+	}
+}
+
+// TestQImportsOf verifies that qImportsOf() finds the names the q package is
+// imported as.
+func TestQImportsOf(t *testing.T) {
+	testCases := []struct {
+		src  string
+		want qImports
+	}{
+		{src: `package main; import "github.com/ryboe/q"`, want: qImports{names: []string{"q"}}},
+		{src: `package main; import dbg "github.com/ryboe/q"`, want: qImports{names: []string{"dbg"}}},
+		{src: `package main; import . "github.com/ryboe/q"`, want: qImports{unqualified: true}},
+		{src: `package main; import _ "github.com/ryboe/q"`, want: qImports{}},
+		{src: `package main; import "example.com/q"`, want: qImports{}},
+		{src: `package q`, want: qImports{unqualified: true}},
+	}
+
+	for _, tc := range testCases {
+		f, err := parser.ParseFile(token.NewFileSet(), "", tc.src, parser.ImportsOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := qImportsOf(f)
+		if !slices.Equal(got.names, tc.want.names) || got.unqualified != tc.want.unqualified {
+			t.Fatalf("\nqImportsOf(%q)\ngot:  %+v\nwant: %+v", tc.src, got, tc.want)
+		}
+	}
+}
+
 // TestArgNamesBadFilename verifies that argNames() returns an error if given an
 // invalid filename.
 func TestArgNamesBadFilename(t *testing.T) {
 	const badFilename = "BAD FILENAME"
-	_, err := argNames(badFilename, 666)
+	_, err := argNames(caller{file: badFilename, line: 666})
 	if err == nil {
 		t.Fatalf("\nargNames(%s)\ngot:  err == nil\nwant: err != nil", badFilename)
 	}
@@ -362,24 +599,31 @@ func TestPrependArgName(t *testing.T) {
 // is q.Q().
 // nolint: funlen
 func TestIsQCall(t *testing.T) {
+	imported := qImports{names: []string{"q"}}
+	aliased := qImports{names: []string{"dbg"}}
+	dotImported := qImports{unqualified: true}
+
 	testCases := []struct {
-		id   int
-		expr *ast.CallExpr
-		want bool
+		id      int
+		expr    *ast.CallExpr
+		imports qImports
+		want    bool
 	}{
 		{
 			id: 1,
 			expr: &ast.CallExpr{
 				Fun: &ast.Ident{Name: "Q"},
 			},
-			want: true,
+			imports: dotImported,
+			want:    true,
 		},
 		{
 			id: 2,
 			expr: &ast.CallExpr{
 				Fun: &ast.Ident{Name: "R"},
 			},
-			want: false,
+			imports: dotImported,
+			want:    false,
 		},
 		{
 			id: 3,
@@ -388,7 +632,8 @@ func TestIsQCall(t *testing.T) {
 					X: &ast.Ident{Name: "q"},
 				},
 			},
-			want: true,
+			imports: imported,
+			want:    true,
 		},
 		{
 			id: 4,
@@ -397,7 +642,8 @@ func TestIsQCall(t *testing.T) {
 					X: &ast.Ident{Name: "Q"},
 				},
 			},
-			want: false,
+			imports: imported,
+			want:    false,
 		},
 		{
 			id: 5,
@@ -406,27 +652,118 @@ func TestIsQCall(t *testing.T) {
 					X: &ast.BadExpr{},
 				},
 			},
-			want: false,
+			imports: imported,
+			want:    false,
 		},
 		{
 			id: 6,
 			expr: &ast.CallExpr{
 				Fun: &ast.Ident{Name: "q"},
 			},
-			want: false,
+			imports: dotImported,
+			want:    false,
+		},
+		{
+			id: 7,
+			expr: &ast.CallExpr{
+				Fun: &ast.Ident{Name: "V"},
+			},
+			imports: dotImported,
+			want:    true,
+		},
+		{
+			id: 8,
+			expr: &ast.CallExpr{
+				Fun: &ast.Ident{Name: "Q"},
+			},
+			imports: imported,
+			want:    false,
+		},
+		{
+			id: 9,
+			expr: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   &ast.Ident{Name: "dbg"},
+					Sel: &ast.Ident{Name: "Q"},
+				},
+			},
+			imports: aliased,
+			want:    true,
+		},
+		{
+			id: 10,
+			expr: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   &ast.Ident{Name: "q"},
+					Sel: &ast.Ident{Name: "Q"},
+				},
+			},
+			imports: aliased,
+			want:    false,
 		},
 	}
 
 	for _, tc := range testCases {
-		got := isQCall(tc.expr)
+		got := isQCall(tc.expr, tc.imports)
 		if got != tc.want {
 			t.Fatalf(
 				"\nTEST %d\nisQCall(%s)\ngot:  %v\nwant: %v",
 				tc.id,
-				pretty.Sprint(tc.expr),
+				exprToString(tc.expr),
 				got,
 				tc.want,
 			)
 		}
 	}
 }
+
+// TestArgNamesCache verifies that argNames() doesn't return stale names after
+// the source file is modified.
+func TestArgNamesCache(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "main.go")
+	writeSource := func(src string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(filename, []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modTime := time.Now().Add(-time.Hour)
+	const header = "package main\n\nimport \"github.com/ryboe/q\"\n\n"
+	writeSource(header+"func main() {\n\tq.Q(a)\n}\n", modTime)
+	got, err := argNames(caller{file: filename, line: 6})
+	if err != nil || !slices.Equal(got, []string{"a"}) {
+		t.Fatalf("\nargNames(%q, 6)\ngot:  %q, %v\nwant: %q, nil", filename, got, err, []string{"a"})
+	}
+
+	writeSource(header+"func main() {\n\tq.Q(b)\n}\n", modTime.Add(time.Second))
+	got, err = argNames(caller{file: filename, line: 6})
+	if err != nil || !slices.Equal(got, []string{"b"}) {
+		t.Fatalf("\nargNames(%q, 6) after modifying the file\ngot:  %q, %v\nwant: %q, nil", filename, got, err, []string{"b"})
+	}
+}
+
+// BenchmarkArgNames measures the cost of finding the argument names of a q.Q()
+// call that has been seen before, e.g. in a loop.
+func BenchmarkArgNames(b *testing.B) {
+	const filename = "testdata/sample1.go"
+	for b.Loop() {
+		if _, err := argNames(caller{file: filename, line: 14}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkParseCalls measures the cost of finding the argument names of a
+// q.Q() call without the cache.
+func BenchmarkParseCalls(b *testing.B) {
+	const filename = "testdata/sample1.go"
+	for b.Loop() {
+		if _, err := parseCalls(filename, 14); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
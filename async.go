@@ -0,0 +1,114 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"time"
+)
+
+// nolint: gochecknoglobals
+var (
+	// Async makes q calls return as soon as the caller info is looked up and
+	// the call is queued. A background goroutine formats the arguments and
+	// writes the log, so q calls don't make goroutines wait for each other,
+	// and timing-sensitive bugs are less likely to disappear when q calls are
+	// added. The arguments are copied when a call is queued, so the log
+	// shows them as they were at the time of the call, even if they're
+	// changed before they're written. If the queue is full, calls are
+	// dropped, and the number of dropped calls is reported in the log. Call
+	// Flush before the program exits, and before turning Async off, so that
+	// no queued calls are lost or written out of order.
+	Async bool
+
+	// AsyncQueueLen is the number of calls that can wait to be written in
+	// async mode. It's read when the first call is queued.
+	AsyncQueueLen = 1024
+)
+
+// event is a q call waiting to be written.
+type event struct {
+	at        time.Time // time of the call
+	c         caller
+	callerErr error
+	render    func(names []string) []string
+
+	flushed chan struct{} // if non-nil, closed instead of writing the event
+}
+
+// Flush waits until the calls queued in async mode are written. It returns
+// immediately if nothing was ever queued.
+func Flush() {
+	std.flushQueue()
+}
+
+// enqueue queues a q call to be written by the background goroutine, or drops
+// it if the queue is full.
+func (l *logger) enqueue(e event) {
+	l.startQueue()
+
+	select {
+	case l.queue <- e:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// flushQueue waits until the events queued before it are written.
+func (l *logger) flushQueue() {
+	if !l.queueReady.Load() {
+		return
+	}
+
+	flushed := make(chan struct{})
+	l.queue <- event{flushed: flushed}
+	<-flushed
+}
+
+// startQueue starts the async queue and the goroutine that writes the events
+// in it, unless they've been started already.
+func (l *logger) startQueue() {
+	l.queueOnce.Do(func() {
+		l.queue = make(chan event, max(AsyncQueueLen, 1))
+		l.queueReady.Store(true)
+
+		go l.drain()
+	})
+}
+
+// drain writes the queued events in order. It runs for the life of the
+// program.
+func (l *logger) drain() {
+	for e := range l.queue {
+		if n := l.dropped.Swap(0); n > 0 {
+			l.reportDropped(n)
+		}
+
+		if e.flushed != nil {
+			close(e.flushed)
+
+			continue
+		}
+
+		l.write(e)
+	}
+}
+
+// reportDropped writes a line saying how many q calls were dropped because the
+// async queue was full.
+func (l *logger) reportDropped(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	calls := "calls"
+	if n == 1 {
+		calls = "call"
+	}
+	msg := fmt.Sprintf("dropped %s q %s, because the async queue was full (q.AsyncQueueLen = %d)", formatCount(int(n)), calls, cap(l.queue))
+	l.output(colorize(msg, red))
+	if err := l.flush(); err != nil {
+		fmt.Println(err)
+	}
+}
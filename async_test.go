@@ -0,0 +1,131 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestAsync verifies that calls made in async mode are all written, in order,
+// by the time Flush returns.
+func TestAsync(t *testing.T) {
+	setTempDir(t)
+	Async = true
+	t.Cleanup(func() {
+		Flush()
+		Async = false
+	})
+
+	const n = 20
+	for i := range n {
+		Q(i)
+	}
+	Flush()
+
+	log := readLog(t)
+	last := -1
+	for i := range n {
+		want := colorize("i", bold) + "=" + colorize("int("+strconv.Itoa(i)+")", cyan)
+		j := strings.Index(log, want)
+		if j < 0 || j < last {
+			t.Fatalf("\nlog:\n%s\nmissing, or out of order: %q", log, want)
+		}
+		last = j
+	}
+}
+
+// TestAsyncDropped verifies that calls are dropped when the queue is full, and
+// that the number of dropped calls is reported in the log.
+func TestAsyncDropped(t *testing.T) {
+	var buf bytes.Buffer
+	l := logger{sink: writerSink{w: &buf}}
+
+	oldLen := AsyncQueueLen
+	AsyncQueueLen = 1
+	t.Cleanup(func() { AsyncQueueLen = oldLen })
+
+	errNoCaller := errors.New("no caller")
+	started, release := make(chan struct{}), make(chan struct{})
+	l.enqueue(event{callerErr: errNoCaller, render: func([]string) []string {
+		// Hold up the worker, so the queue fills up.
+		close(started)
+		<-release
+
+		return []string{"first"}
+	}})
+	<-started
+
+	for _, msg := range []string{"second", "third", "fourth"} {
+		l.enqueue(event{callerErr: errNoCaller, render: func([]string) []string { return []string{msg} }})
+	}
+	close(release)
+	l.flushQueue()
+
+	got := buf.String()
+	for _, want := range []string{"first", "dropped 2 q calls", "second"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("\nlog:\n%s\nmissing: %q", got, want)
+		}
+	}
+	for _, notWant := range []string{"third", "fourth"} {
+		if strings.Contains(got, notWant) {
+			t.Fatalf("\nlog:\n%s\nunexpected: %q", got, notWant)
+		}
+	}
+}
+
+// TestAsyncTraceDropped verifies that q.Trace() keeps the right depth in async
+// mode when its entry line is dropped because the queue is full.
+func TestAsyncTraceDropped(t *testing.T) {
+	var buf bytes.Buffer
+	l := logger{sink: writerSink{w: &buf}}
+
+	oldLen := AsyncQueueLen
+	AsyncQueueLen = 1
+	Async = true
+	t.Cleanup(func() {
+		AsyncQueueLen = oldLen
+		Async = false
+	})
+
+	errNoCaller := errors.New("no caller")
+	started, release := make(chan struct{}), make(chan struct{})
+	l.enqueue(event{callerErr: errNoCaller, render: func([]string) []string {
+		// Hold up the worker, so the queue fills up.
+		close(started)
+		<-release
+
+		return []string{"first"}
+	}})
+	<-started
+	l.enqueue(event{callerErr: errNoCaller, render: func([]string) []string { return []string{"second"} }})
+
+	c := caller{funcName: "main.traced"}
+	exit := l.trace(c, errNoCaller) // the entry line is dropped
+	close(release)
+	l.flushQueue()
+	exit()
+	l.flushQueue()
+	exit = l.trace(c, errNoCaller)
+	l.flushQueue()
+	exit()
+	l.flushQueue()
+
+	got := buf.String()
+	want := "← " + colorize("main.traced", bold)
+	if n := strings.Count(got, want); n != 2 {
+		t.Fatalf("\nlog:\n%s\nwant 2 exit lines, got %d", got, n)
+	}
+	if strings.Contains(got, traceIndent+"→") || strings.Contains(got, traceIndent+"←") {
+		t.Fatalf("\nlog:\n%s\nthe traced calls aren't nested, so their lines shouldn't be indented", got)
+	}
+	if len(l.traceDepth) != 0 {
+		t.Fatalf("\ntraceDepth should be empty after all traced calls return\ngot:  %v", l.traceDepth)
+	}
+}
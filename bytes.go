@@ -0,0 +1,198 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// hexdumpWidth is the number of bytes on each line of a hexdump.
+const hexdumpWidth = 16
+
+// hexBytes is a byte slice that's always printed as a hexdump.
+type hexBytes []byte
+
+// QFormat implements QFormatter.
+func (b hexBytes) QFormat() string {
+	return formatBytes("", limitBytes(b), len(b), true)
+}
+
+// Hex makes q print the given bytes as a hexdump, the way hexdump -C does,
+// even if they're text, e.g. q.Q(q.Hex(packet)).
+func Hex[T ~[]byte | ~string](b T) QFormatter {
+	return hexBytes(b)
+}
+
+// bytesValue formats a byte slice or array as a quoted string if it's text, or
+// as a hexdump if it looks binary.
+func (f *formatter) bytesValue(v reflect.Value, showType bool) string {
+	typ := ""
+	if showType {
+		typ = v.Type().String()
+	}
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		if showType {
+			return typ + "(nil)"
+		}
+
+		return "nil"
+	}
+
+	// Only the bytes that will be printed are read, so that big buffers are
+	// cheap to print.
+	n := originalLen(v, f.lengths)
+	shown := v.Len()
+	if MaxStringLen > 0 {
+		shown = min(shown, MaxStringLen)
+	}
+
+	var b []byte
+	if v.CanInterface() && (v.Kind() == reflect.Slice || v.CanAddr()) {
+		b = v.Bytes()[:shown]
+	} else {
+		// Values read from unexported fields have to be copied one byte at
+		// a time.
+		b = make([]byte, shown)
+		for i := range b {
+			b[i] = byte(v.Index(i).Uint())
+		}
+	}
+
+	if text := trimPartialRune(b, shown < n); isText(text) {
+		return formatBytes(typ, text, n, false)
+	}
+
+	return formatBytes(typ, b, n, true)
+}
+
+// limitBytes returns the first MaxStringLen bytes of b.
+func limitBytes(b []byte) []byte {
+	if MaxStringLen > 0 && len(b) > MaxStringLen {
+		return b[:MaxStringLen]
+	}
+
+	return b
+}
+
+// trimPartialRune removes the incomplete rune at the end of b, if b was cut
+// short, so that text cut in the middle of a rune is still seen as text.
+func trimPartialRune(b []byte, cut bool) []byte {
+	if !cut {
+		return b
+	}
+
+	for i := len(b) - 1; i >= max(len(b)-utf8.UTFMax+1, 0); i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+
+			break
+		}
+	}
+
+	return b
+}
+
+// formatBytes formats b, the first bytes of n, as a hexdump or a quoted
+// string, preceded by the given type. If b is shorter than n, the number of
+// bytes left out is printed after it.
+func formatBytes(typ string, b []byte, n int, hex bool) string {
+	if n == 0 {
+		return typ + "{}"
+	}
+
+	more := n - len(b)
+
+	if !hex {
+		s := strconv.Quote(string(b))
+		if typ != "" {
+			s = typ + "(" + s + ")"
+		}
+		if more > 0 {
+			s += "… " + formatCount(more) + " more bytes"
+		}
+
+		return s
+	}
+
+	lines := hexdump(b)
+	if more > 0 {
+		lines = append(lines, "… "+formatCount(more)+" more bytes")
+	}
+
+	return typ + "{\n" + formatIndent + strings.Join(lines, "\n"+formatIndent) + "\n}"
+}
+
+// hexdump returns the lines of a hexdump of b, in the format of hexdump -C:
+//
+//	00000000  68 65 6c 6c 6f 20 77 6f  72 6c 64 0a              |hello world.|
+//	0000000c
+//
+// Like hexdump, runs of identical lines are replaced by a single "*".
+func hexdump(b []byte) []string {
+	var lines []string
+	var prev []byte
+	squeezed := false
+	for offset := 0; offset < len(b); offset += hexdumpWidth {
+		row := b[offset:min(offset+hexdumpWidth, len(b))]
+		if len(row) == hexdumpWidth && string(row) == string(prev) {
+			if !squeezed {
+				lines = append(lines, "*")
+				squeezed = true
+			}
+
+			continue
+		}
+		prev, squeezed = row, false
+
+		var line strings.Builder
+		fmt.Fprintf(&line, "%08x  ", offset)
+		for i := range hexdumpWidth {
+			if i == hexdumpWidth/2 {
+				line.WriteByte(' ')
+			}
+			if i < len(row) {
+				fmt.Fprintf(&line, "%02x ", row[i])
+			} else {
+				line.WriteString("   ")
+			}
+		}
+
+		line.WriteString(" |")
+		for _, c := range row {
+			if c < ' ' || c > '~' {
+				c = '.'
+			}
+			line.WriteByte(c)
+		}
+		line.WriteString("|")
+
+		lines = append(lines, line.String())
+	}
+
+	return append(lines, fmt.Sprintf("%08x", len(b)))
+}
+
+// isText returns true if b is valid UTF-8 made of printable characters and
+// whitespace, so it's more readable as a string than as a hexdump.
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestHexdump verifies that hexdump() matches the output of hexdump -C.
+func TestHexdump(t *testing.T) {
+	testCases := []struct {
+		id   int
+		b    []byte
+		want []string
+	}{
+		{
+			id: 1,
+			b:  []byte("hello world\n"),
+			want: []string{
+				"00000000  68 65 6c 6c 6f 20 77 6f  72 6c 64 0a              |hello world.|",
+				"0000000c",
+			},
+		},
+		{
+			id: 2,
+			b:  append([]byte("hello world\n"), 0x00, 0xff, 0x01, 0x02, 0x7f),
+			want: []string{
+				"00000000  68 65 6c 6c 6f 20 77 6f  72 6c 64 0a 00 ff 01 02  |hello world.....|",
+				"00000010  7f                                                |.|",
+				"00000011",
+			},
+		},
+		{
+			id: 3,
+			b:  append(make([]byte, 64), 1),
+			want: []string{
+				"00000000  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|",
+				"*",
+				"00000040  01                                                |.|",
+				"00000041",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := hexdump(tc.b); !slices.Equal(got, tc.want) {
+			t.Fatalf("\nTEST %d\ngot:\n%s\nwant:\n%s", tc.id, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
+
+// TestFormatBytes verifies that byte slices are printed as strings if they're
+// text, and as hexdumps if they look binary.
+func TestFormatBytes(t *testing.T) {
+	type packet struct {
+		Header [4]byte
+		Body   []byte
+	}
+
+	testCases := []struct {
+		id   int
+		v    any
+		want string
+	}{
+		{id: 1, v: []byte("GET / HTTP/1.1\r\n"), want: `[]uint8("GET / HTTP/1.1\r\n")`},
+		{id: 2, v: []byte("héllo"), want: `[]uint8("héllo")`},
+		{id: 3, v: []byte{}, want: "[]uint8{}"},
+		{id: 4, v: []byte(nil), want: "[]uint8(nil)"},
+		{
+			id:   5,
+			v:    []byte{0xde, 0xad, 0xbe, 0xef},
+			want: "[]uint8{\n    00000000  de ad be ef                                       |....|\n    00000004\n}",
+		},
+		{
+			id: 6,
+			v:  packet{Header: [4]byte{0, 0, 0, 2}, Body: []byte("hi")},
+			want: "q.packet{\n" +
+				"    Header: {\n" +
+				"        00000000  00 00 00 02                                       |....|\n" +
+				"        00000004\n" +
+				"    },\n" +
+				"    Body: \"hi\",\n" +
+				"}",
+		},
+		{
+			id:   7,
+			v:    Hex("hi"),
+			want: "{\n    00000000  68 69                                             |hi|\n    00000002\n}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := format(tc.v); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:\n%s\nwant:\n%s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatBytesLimit verifies that long byte slices are cut short.
+func TestFormatBytesLimit(t *testing.T) {
+	setLimits(t, 0, 4, 0, 0)
+
+	got := format([]byte("hello world"))
+	if want := `[]uint8("hell")… 7 more bytes`; got != want {
+		t.Fatalf("\ngot:  %s\nwant: %s", got, want)
+	}
+
+	got = format(Hex("hello world"))
+	want := "{\n    00000000  68 65 6c 6c                                       |hell|\n    00000004\n    … 7 more bytes\n}"
+	if got != want {
+		t.Fatalf("\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestFormatBytesLimitRead verifies that only the bytes that are printed are
+// read, including from unexported fields, and that text cut in the middle of a
+// rune is still printed as text.
+func TestFormatBytesLimitRead(t *testing.T) {
+	setLimits(t, 0, 4, 0, 0)
+
+	type buffer struct{ data []byte }
+
+	testCases := []struct {
+		id   int
+		v    any
+		want string
+	}{
+		{id: 1, v: buffer{data: []byte("hello world")}, want: "q.buffer{\n    data: \"hell\"… 7 more bytes,\n}"},
+		{id: 2, v: []byte("abcé"), want: `[]uint8("abc")… 2 more bytes`},
+		{id: 3, v: [6]byte{'a', 'b', 'c', 'd', 'e', 'f'}, want: `[6]uint8("abcd")… 2 more bytes`},
+		{
+			id:   4,
+			v:    append([]byte{0xff}, make([]byte, 1<<20)...),
+			want: "[]uint8{\n    00000000  ff 00 00 00                                       |....|\n    00000004\n    … 1,048,573 more bytes\n}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := format(tc.v); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:\n%s\nwant:\n%s", tc.id, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"path"
+	"runtime"
+	"sync"
+)
+
+// CallSite is a call to a q function found in the source text. CallSites are
+// normally found by parsing the source file of the code calling q, but they can
+// also be compiled into the binary with the qgen command, for binaries that run
+// where their source files aren't available. See RegisterCallSites.
+type CallSite struct {
+	Func       string   // name of the called function, e.g. Q or V
+	StartLine  int      // line the call starts on
+	LparenLine int      // line of the opening paren
+	RparenLine int      // line of the closing paren
+	Args       []string // source text of the arguments, or nil for q.Q(v...)
+}
+
+// callSiteRegistry holds the call sites compiled into the binary, by source
+// file. It is safe for concurrent use.
+type callSiteRegistry struct {
+	mu    sync.RWMutex
+	files map[string][]CallSite
+}
+
+// nolint: gochecknoglobals
+var registeredCallSites callSiteRegistry
+
+// RegisterCallSites records the q calls in the given source file, so that their
+// argument names can be printed without reading the file. The file name must be
+// relative to the directory of the file calling RegisterCallSites, which must
+// be in the same package. It's meant to be called from the init function
+// generated by the qgen command:
+//
+//	//go:generate go run github.com/ryboe/q/cmd/qgen
+//
+// The table goes stale when the source file changes, so qgen must be rerun
+// before every build.
+func RegisterCallSites(file string, sites []CallSite) {
+	_, generated, _, ok := runtime.Caller(1)
+	if !ok {
+		return
+	}
+
+	// runtime.Caller() reports the same kind of path for every file in the
+	// package, even in -trimpath builds, so the registered path matches the one
+	// reported for the q calls.
+	file = path.Join(path.Dir(generated), file)
+
+	registeredCallSites.mu.Lock()
+	defer registeredCallSites.mu.Unlock()
+
+	if registeredCallSites.files == nil {
+		registeredCallSites.files = map[string][]CallSite{}
+	}
+	registeredCallSites.files[file] = sites
+}
+
+// FindCallSites parses the given source file and returns the q calls in it.
+// Calls on the same line are in the order they're evaluated. It's used by the
+// qgen command to generate the calls to RegisterCallSites.
+func FindCallSites(filename string) ([]CallSite, error) {
+	return parseFile(filename)
+}
+
+// lookup returns the registered calls that span the given line of the given
+// file. It returns false if no calls were registered for the file.
+func (r *callSiteRegistry) lookup(file string, line int) ([]CallSite, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	calls, ok := r.files[file]
+	if !ok {
+		return nil, false
+	}
+
+	return callsOnLine(calls, line), true
+}
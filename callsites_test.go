@@ -0,0 +1,76 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"path"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// TestRegisterCallSites verifies that argNames() uses the registered call sites
+// instead of reading the source file, which doesn't exist.
+func TestRegisterCallSites(t *testing.T) {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("failed to get the path of the test file")
+	}
+	missingFile := path.Join(path.Dir(file), "missing.go")
+
+	RegisterCallSites("missing.go", []CallSite{
+		{Func: "V", StartLine: 7, LparenLine: 7, RparenLine: 7, Args: []string{"a"}},
+		{Func: "Q", StartLine: 6, LparenLine: 6, RparenLine: 9, Args: []string{"q.V(a)", "b"}},
+	})
+	t.Cleanup(func() {
+		registeredCallSites.mu.Lock()
+		defer registeredCallSites.mu.Unlock()
+		delete(registeredCallSites.files, missingFile)
+	})
+
+	testCases := []struct {
+		c    caller
+		want []string
+	}{
+		{c: caller{file: missingFile, line: 6, qFunc: "Q"}, want: []string{"q.V(a)", "b"}},
+		{c: caller{file: missingFile, line: 7, qFunc: "V"}, want: []string{"a"}},
+		{c: caller{file: missingFile, line: 8, qFunc: "Q"}, want: []string{"q.V(a)", "b"}},
+		{c: caller{file: missingFile, line: 10, qFunc: "Q"}, want: nil},
+	}
+
+	for _, tc := range testCases {
+		got, err := argNames(tc.c)
+		if err != nil {
+			t.Fatalf("\nargNames(%s:%d)\ngot error: %v", tc.c.file, tc.c.line, err)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("\nargNames(%s:%d)\ngot:  %q\nwant: %q", tc.c.file, tc.c.line, got, tc.want)
+		}
+	}
+}
+
+// TestFindCallSites verifies that FindCallSites() finds every q call in a
+// file, with the lines it spans.
+func TestFindCallSites(t *testing.T) {
+	got, err := FindCallSites("testdata/sample3.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []CallSite{
+		{Func: "V", StartLine: 9, LparenLine: 9, RparenLine: 9, Args: []string{"a"}},
+		{Func: "Q", StartLine: 9, LparenLine: 9, RparenLine: 9, Args: []string{"q.V(a)"}},
+		{Func: "V", StartLine: 10, LparenLine: 10, RparenLine: 10, Args: []string{"b"}},
+		{Func: "V", StartLine: 10, LparenLine: 10, RparenLine: 10, Args: []string{"c"}},
+		{Func: "Q", StartLine: 11, LparenLine: 11, RparenLine: 11},
+	}
+
+	if !slices.EqualFunc(got, want, func(a, b CallSite) bool {
+		return a.Func == b.Func && a.StartLine == b.StartLine && a.LparenLine == b.LparenLine &&
+			a.RparenLine == b.RparenLine && slices.Equal(a.Args, b.Args)
+	}) {
+		t.Fatalf("\nFindCallSites(%q)\ngot:  %+v\nwant: %+v", "testdata/sample3.go", got, want)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Command qgen compiles the argument names of a package's q calls into the
+// package, for binaries that run where their source files aren't available,
+// e.g. in containers or when built with -trimpath. Without it, q.Q(port) prints
+// 443 instead of port=443 in those binaries.
+//
+// Add this line to one file in the package, and run go generate before
+// building:
+//
+//	//go:generate go run github.com/ryboe/q/cmd/qgen
+//
+// qgen writes a q_callsites.go file that registers every q call in the package
+// with q.RegisterCallSites. The file must be regenerated whenever the q calls
+// move, so it's best to run go generate as part of the build.
+//
+// Usage:
+//
+//	qgen [-o file] [dir]
+//
+// dir defaults to the current directory.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/ryboe/q"
+)
+
+func main() {
+	output := flag.String("o", "q_callsites.go", "name of the generated file")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: qgen [-o file] [dir]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	if err := run(dir, *output); err != nil {
+		fmt.Fprintln(os.Stderr, "qgen:", err)
+		os.Exit(1)
+	}
+}
+
+// run writes the generated file to the given directory. If the package has no q
+// calls, a previously generated file is removed instead, because it would no
+// longer compile.
+func run(dir, output string) error {
+	src, err := generate(dir, output)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, output)
+	if src == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove %q: %w", path, err)
+		}
+
+		return nil
+	}
+
+	if err := os.WriteFile(path, src, 0o644); err != nil { // nolint: gosec
+		return fmt.Errorf("failed to write %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// generate returns the source of a file that registers the q calls of the
+// package in the given directory. Test files are included, unless they're in
+// an external test package. The file named output is skipped, since it's the
+// one being generated. generate returns nil if there are no q calls.
+func generate(dir, output string) ([]byte, error) {
+	pkgName, files, err := packageFiles(dir, output)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, file := range files {
+		sites, err := q.FindCallSites(filepath.Join(dir, file))
+		if err != nil {
+			return nil, err // nolint: wrapcheck
+		}
+		if len(sites) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "q.RegisterCallSites(%q, []q.CallSite{\n", file)
+		for _, site := range sites {
+			fmt.Fprintf(&buf, "{Func: %q, StartLine: %d, LparenLine: %d, RparenLine: %d, Args: %#v},\n",
+				site.Func, site.StartLine, site.LparenLine, site.RparenLine, site.Args)
+		}
+		fmt.Fprintln(&buf, "})")
+	}
+
+	if buf.Len() == 0 {
+		return nil, nil
+	}
+
+	src := fmt.Sprintf(`// Code generated by qgen. DO NOT EDIT.
+
+package %s
+
+import "github.com/ryboe/q"
+
+func init() {
+%s}
+`, pkgName, buf.String())
+
+	formatted, err := format.Source([]byte(src))
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w", err)
+	}
+
+	return formatted, nil
+}
+
+// packageFiles returns the name of the package in the given directory, and the
+// names of its Go files, sorted. The package is the one go build would build,
+// so files of another package, e.g. a //go:build ignore program in package
+// main, are left out. Files of the package that are only excluded by build
+// constraints, e.g. foo_windows.go, are kept, so that the generated file works
+// for every platform. Files in an external test package, and the file named
+// output, are left out too.
+func packageFiles(dir, output string) (string, []string, error) {
+	ctxt := build.Default
+	ctxt.ReadDir = func(dir string) ([]fs.FileInfo, error) {
+		// The previously generated file is skipped, in case it's stale.
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err // nolint: wrapcheck
+		}
+
+		infos := make([]fs.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			if entry.Name() == output {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err // nolint: wrapcheck
+			}
+			infos = append(infos, info)
+		}
+
+		return infos, nil
+	}
+
+	pkg, err := ctxt.ImportDir(dir, 0)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load the package in %q: %w", dir, err)
+	}
+
+	// The generated file can't register calls in an external test package.
+	files := slices.Concat(pkg.GoFiles, pkg.CgoFiles, pkg.TestGoFiles)
+	for _, name := range pkg.IgnoredGoFiles {
+		f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to parse %q: %w", name, err)
+		}
+		if f.Name.Name == pkg.Name {
+			files = append(files, name)
+		}
+	}
+	slices.Sort(files)
+
+	return pkg.Name, files, nil
+}
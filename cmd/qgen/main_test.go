@@ -0,0 +1,101 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// nolint: gochecknoglobals
+var update = flag.Bool("update", false, "update the golden files")
+
+// TestGenerate verifies that generate() registers the q calls of every file in
+// the package, except the ones in an external test package.
+func TestGenerate(t *testing.T) {
+	const dir = "testdata/pkg"
+	golden := filepath.Join(dir, "q_callsites.go.golden")
+
+	got, err := generate(dir, "q_callsites.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		if err := os.WriteFile(golden, got, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("\ngenerate(%q)\ngot:\n%s\nwant:\n%s", dir, got, want)
+	}
+}
+
+// TestRunNoCalls verifies that run() removes the generated file when the
+// package no longer has any q calls.
+func TestRunNoCalls(t *testing.T) {
+	dir := t.TempDir()
+	src := []byte("package main\n\nfunc main() {}\n")
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), src, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	generated := filepath.Join(dir, "q_callsites.go")
+	if err := os.WriteFile(generated, []byte("package main\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run(dir, "q_callsites.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(generated); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("\nrun(%q) didn't remove %q\ngot:  %v\nwant: %v", dir, generated, err, fs.ErrNotExist)
+	}
+}
+
+// TestGenerateBuildConstraints verifies that generate() takes the package name
+// from the package go build would build, not from a file that's excluded from
+// it, and that files excluded only on other platforms still get registered.
+func TestGenerateBuildConstraints(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// gen.go sorts before pkg.go, like a typical generator program.
+		"gen.go":         "//go:build ignore\n\npackage main\n\nfunc main() {}\n",
+		"pkg.go":         "package pkg\n\nimport \"github.com/ryboe/q\"\n\nfunc f(a int) { q.Q(a) }\n",
+		"pkg_plan9.go":   "package pkg\n\nimport \"github.com/ryboe/q\"\n\nfunc g(b int) { q.Q(b) }\n",
+		"q_callsites.go": "package stale\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := generate(dir, "q_callsites.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"package pkg\n", `"pkg.go"`, `"pkg_plan9.go"`} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Fatalf("\ngenerate(%q)\ngot:\n%s\nwant it to contain: %s", dir, got, want)
+		}
+	}
+	if bytes.Contains(got, []byte(`"gen.go"`)) {
+		t.Fatalf("\ngenerate(%q)\ngot:\n%s\nwant it not to contain: %s", dir, got, `"gen.go"`)
+	}
+}
@@ -0,0 +1,11 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/ryboe/q"
+)
+
+func TestExternal(t *testing.T) {
+	q.Q(t)
+}
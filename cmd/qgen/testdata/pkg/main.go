@@ -0,0 +1,15 @@
+package main
+
+import "github.com/ryboe/q"
+
+func main() {
+	a, b := 1, 2
+	xs := []any{a, b}
+
+	q.Q(a, b, 3)
+	q.Q(
+		q.V(a),
+		b,
+	)
+	q.Q(xs...)
+}
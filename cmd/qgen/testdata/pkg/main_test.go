@@ -0,0 +1,11 @@
+package main
+
+import (
+	"testing"
+
+	dbg "github.com/ryboe/q"
+)
+
+func TestMain(t *testing.T) {
+	dbg.Q(helper())
+}
@@ -0,0 +1,374 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"cmp"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// missing is printed in place of a value that only exists on one side of a
+// diff.
+const missing = "(missing)"
+
+// diffEntry is a single leaf of a flattened value, e.g. the Port field in
+// cfg.Servers[2].Port.
+type diffEntry struct {
+	path  string // e.g. ".Servers[2].Port"
+	value string // e.g. "443"
+	empty bool   // true if the leaf is an empty, non-nil slice, map, or struct
+}
+
+// flattener walks a value and collects its leaves as diffEntries.
+type flattener struct {
+	entries []diffEntry
+	visited map[uintptr]bool // pointers on the current path, for cycle detection
+}
+
+// flatten returns the leaves of the given value in a stable order. Pointers
+// and interfaces are followed, so they don't appear in the paths.
+func flatten(v any) []diffEntry {
+	f := flattener{visited: map[uintptr]bool{}}
+	f.walk("", addressable(reflect.ValueOf(v)))
+
+	return f.entries
+}
+
+// walk adds the leaves of v to the flattener's entries. path is the path to v
+// from the root value.
+// nolint: cyclop,gocyclo
+func (f *flattener) walk(path string, v reflect.Value) {
+	if s, ok := customFormat(v); ok {
+		// Values with a custom formatter are compared by their formatted
+		// form, since that's how they're printed.
+		f.leaf(path, s)
+
+		return
+	}
+	if s, ok := stdlibFormat(v, false); ok {
+		f.leaf(path, s)
+
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			f.leaf(path, "nil")
+
+			return
+		}
+
+		ptr := v.Pointer()
+		if f.visited[ptr] {
+			f.leaf(path, "<cycle>")
+
+			return
+		}
+		f.visited[ptr] = true
+		f.walk(path, v.Elem())
+		delete(f.visited, ptr)
+	case reflect.Interface:
+		if v.IsNil() {
+			f.leaf(path, "nil")
+
+			return
+		}
+		f.walk(path, v.Elem())
+	case reflect.Struct:
+		if v.NumField() == 0 {
+			f.empty(path, v.Type().String()+"{}")
+
+			return
+		}
+		for i := range v.NumField() {
+			field := v.Type().Field(i)
+			if redactField(field) {
+				f.leaf(path+"."+field.Name, redacted(v.Field(i)))
+
+				continue
+			}
+			f.walk(path+"."+field.Name, v.Field(i))
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			f.leaf(path, "nil")
+
+			return
+		}
+		fallthrough
+	case reflect.Array:
+		if v.Len() == 0 {
+			f.empty(path, v.Type().String()+"{}")
+
+			return
+		}
+		for i := range v.Len() {
+			f.walk(path+"["+strconv.Itoa(i)+"]", v.Index(i))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			f.leaf(path, "nil")
+
+			return
+		}
+		if v.Len() == 0 {
+			f.empty(path, v.Type().String()+"{}")
+
+			return
+		}
+		keys := v.MapKeys()
+		slices.SortFunc(keys, compareKeys)
+		seen := make(map[string]int, len(keys))
+		for _, k := range keys {
+			keyPath := path + "[" + f.keyString(k, seen) + "]"
+			if redactKey(k) {
+				f.leaf(keyPath, redacted(v.MapIndex(k)))
+
+				continue
+			}
+			f.walk(keyPath, v.MapIndex(k))
+		}
+	default:
+		f.leaf(path, leafString(v))
+	}
+}
+
+// keyString returns the map key k as it's printed, on one line, for use in a
+// path, e.g. {X:1, Y:2} in m[{X:1, Y:2}]. seen counts the keys of the map
+// printed so far, so that different keys that print the same, like two
+// pointers to equal values, get different paths, e.g. m[&q.T{}#2].
+func (f *flattener) keyString(k reflect.Value, seen map[string]int) string {
+	kf := formatter{oneLine: true, visited: map[visit]bool{}}
+	s := kf.value(k, false, true)
+
+	seen[s]++
+	if n := seen[s]; n > 1 {
+		s += "#" + strconv.Itoa(n)
+	}
+
+	return s
+}
+
+// leaf adds a leaf value to the flattener's entries.
+func (f *flattener) leaf(path, value string) {
+	f.entries = append(f.entries, diffEntry{path: path, value: value})
+}
+
+// empty adds an empty container to the flattener's entries. Empty containers
+// are dropped from a diff if the other side has leaves inside the container.
+func (f *flattener) empty(path, value string) {
+	f.entries = append(f.entries, diffEntry{path: path, value: value, empty: true})
+}
+
+// compareKeys orders map keys so that diffs and printed maps are
+// deterministic, the way fmt does:
+//
+//   - Keys of different types are ordered by kind, then by type name.
+//   - Numbers, strings, and bools are ordered by value, false before true.
+//   - Pointers and channels are ordered by address, nil first.
+//   - Structs and arrays are ordered by their first field or element that
+//     differs.
+//   - Interfaces are ordered by the values inside them, nil first.
+//
+// nolint: cyclop
+func compareKeys(a, b reflect.Value) int {
+	if a.Kind() == reflect.Interface || b.Kind() == reflect.Interface {
+		if c, ok := compareNil(a, b); ok {
+			return c
+		}
+
+		return compareKeys(unwrapInterface(a), unwrapInterface(b))
+	}
+	if a.Type() != b.Type() {
+		if c := cmp.Compare(a.Kind(), b.Kind()); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a.Type().String(), b.Type().String())
+	}
+
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	case reflect.Complex64, reflect.Complex128:
+		if c := cmp.Compare(real(a.Complex()), real(b.Complex())); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(imag(a.Complex()), imag(b.Complex()))
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Bool:
+		return cmp.Compare(boolInt(a.Bool()), boolInt(b.Bool()))
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return cmp.Compare(a.Pointer(), b.Pointer())
+	case reflect.Struct:
+		for i := range a.NumField() {
+			if c := compareKeys(a.Field(i), b.Field(i)); c != 0 {
+				return c
+			}
+		}
+	case reflect.Array:
+		for i := range a.Len() {
+			if c := compareKeys(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return 0
+}
+
+// compareNil orders a nil interface before a non-nil one. It returns false if
+// neither is nil.
+func compareNil(a, b reflect.Value) (int, bool) {
+	aNil := a.Kind() == reflect.Interface && a.IsNil()
+	bNil := b.Kind() == reflect.Interface && b.IsNil()
+	if !aNil && !bNil {
+		return 0, false
+	}
+
+	return cmp.Compare(boolInt(!aNil), boolInt(!bNil)), true
+}
+
+// unwrapInterface returns the value inside the interface v, or v itself if it
+// isn't an interface.
+func unwrapInterface(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Interface {
+		return v.Elem()
+	}
+
+	return v
+}
+
+// boolInt returns 1 for true and 0 for false.
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// leafString returns the string form of a value that has no children, e.g. a
+// number or a string. It doesn't require the value to be exported.
+func leafString(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+	case reflect.Complex64, reflect.Complex128:
+		return strconv.FormatComplex(v.Complex(), 'g', -1, v.Type().Bits())
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		if v.IsNil() {
+			return "nil"
+		}
+
+		return v.Type().String() + "(0x" + strconv.FormatUint(uint64(v.Pointer()), 16) + ")"
+	case reflect.Invalid:
+		return "nil"
+	default:
+		return v.Type().String()
+	}
+}
+
+// diffValues compares two flattened values and returns a line for every leaf
+// that changed, e.g. "cfg.Servers[2].Port: 80 → 443". The paths are prefixed
+// with the given name. If nothing changed, diffValues returns nil.
+func diffValues(name string, before, after []diffEntry) []string {
+	// Both sides must be filtered against the unfiltered other side.
+	before, after = dropFilledEmpties(before, after), dropFilledEmpties(after, before)
+
+	afterValues := make(map[string]string, len(after))
+	for _, e := range after {
+		afterValues[e.path] = e.value
+	}
+	beforeValues := make(map[string]string, len(before))
+	for _, e := range before {
+		beforeValues[e.path] = e.value
+	}
+
+	var lines []string
+	for _, e := range before {
+		newValue, ok := afterValues[e.path]
+		switch {
+		case !ok:
+			lines = append(lines, diffLine(name, e.path, e.value, missing))
+		case newValue != e.value:
+			lines = append(lines, diffLine(name, e.path, e.value, newValue))
+		}
+	}
+	for _, e := range after {
+		if _, ok := beforeValues[e.path]; !ok {
+			lines = append(lines, diffLine(name, e.path, missing, e.value))
+		}
+	}
+
+	return lines
+}
+
+// dropFilledEmpties removes empty containers from entries if other has leaves
+// inside the same container. When a slice grows from empty to [1], we want to
+// print "s[0]: (missing) → 1", not also "s: []int{} → (missing)".
+func dropFilledEmpties(entries, other []diffEntry) []diffEntry {
+	return slices.DeleteFunc(slices.Clone(entries), func(e diffEntry) bool {
+		if !e.empty {
+			return false
+		}
+
+		return slices.ContainsFunc(other, func(o diffEntry) bool {
+			return isChildPath(o.path, e.path)
+		})
+	})
+}
+
+// isChildPath returns true if path is inside the value at parent, e.g.
+// ".Servers[2].Port" is inside ".Servers".
+func isChildPath(path, parent string) bool {
+	if len(path) <= len(parent) || !strings.HasPrefix(path, parent) {
+		return false
+	}
+
+	next := path[len(parent)]
+
+	return next == '.' || next == '['
+}
+
+// diffLine returns a colorized line describing a single changed leaf, e.g.
+// "cfg.Port: 80 → 443".
+func diffLine(name, path, before, after string) string {
+	change := colorize(before, red) + " → " + colorize(after, green)
+	path = strings.TrimPrefix(name+path, ".")
+	if path == "" {
+		return change
+	}
+
+	return colorize(path, bold) + ": " + change
+}
+
+// summaryLine returns a line describing a value as a whole, e.g.
+// "cfg: no differences".
+func summaryLine(name, summary string) string {
+	if name == "" {
+		return summary
+	}
+
+	return colorize(name, bold) + ": " + summary
+}
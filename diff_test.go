@@ -0,0 +1,227 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type server struct {
+	Host string
+	Port int
+}
+
+type config struct {
+	Name    string
+	Servers []server
+	Labels  map[string]string
+	next    *config
+}
+
+// TestFlatten verifies that flatten() returns the leaves of a value with their
+// paths.
+func TestFlatten(t *testing.T) {
+	cfg := &config{
+		Name:    "prod",
+		Servers: []server{{Host: "a", Port: 80}},
+		Labels:  map[string]string{"b": "2", "a": "1"},
+	}
+	cfg.next = cfg // cycles must not recurse forever
+
+	want := []diffEntry{
+		{path: ".Name", value: `"prod"`},
+		{path: ".Servers[0].Host", value: `"a"`},
+		{path: ".Servers[0].Port", value: "80"},
+		{path: `.Labels["a"]`, value: `"1"`},
+		{path: `.Labels["b"]`, value: `"2"`},
+		{path: ".next", value: "<cycle>"},
+	}
+
+	got := flatten(cfg)
+	if !slices.Equal(got, want) {
+		t.Fatalf("\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
+
+// TestDiffValues verifies that diffValues() returns a line for every leaf that
+// was changed, added, or removed.
+// nolint: funlen
+func TestDiffValues(t *testing.T) {
+	testCases := []struct {
+		id            int
+		name          string
+		before, after any
+		want          []string
+	}{
+		{
+			id:     1,
+			name:   "port",
+			before: 80,
+			after:  443,
+			want:   []string{colorize("port", bold) + ": " + colorize("80", red) + " → " + colorize("443", green)},
+		},
+		{
+			id:     2,
+			before: 80,
+			after:  443,
+			want:   []string{colorize("80", red) + " → " + colorize("443", green)},
+		},
+		{
+			id:     3,
+			name:   "cfg",
+			before: config{Servers: []server{{Port: 80}, {Port: 80}, {Port: 80}}},
+			after:  config{Servers: []server{{Port: 80}, {Port: 80}, {Port: 443}}},
+			want:   []string{colorize("cfg.Servers[2].Port", bold) + ": " + colorize("80", red) + " → " + colorize("443", green)},
+		},
+		{
+			id:     4,
+			before: config{Labels: map[string]string{"a": "1"}},
+			after:  config{Labels: map[string]string{"b": "2"}},
+			want: []string{
+				colorize(`Labels["a"]`, bold) + ": " + colorize(`"1"`, red) + " → " + colorize(missing, green),
+				colorize(`Labels["b"]`, bold) + ": " + colorize(missing, red) + " → " + colorize(`"2"`, green),
+			},
+		},
+		{
+			id:     5,
+			name:   "s",
+			before: []int{},
+			after:  []int{7},
+			want:   []string{colorize("s[0]", bold) + ": " + colorize(missing, red) + " → " + colorize("7", green)},
+		},
+		{
+			id:     6,
+			name:   "cfg",
+			before: &config{Name: "prod"},
+			after:  &config{Name: "prod"},
+			want:   nil,
+		},
+		{
+			id:     7,
+			name:   "m",
+			before: map[server]int{{Host: "a", Port: 1}: 1, {Host: "b", Port: 2}: 2},
+			after:  map[server]int{{Host: "a", Port: 1}: 1, {Host: "b", Port: 2}: 5},
+			want:   []string{colorize(`m[{Host:"b", Port:2}]`, bold) + ": " + colorize("2", red) + " → " + colorize("5", green)},
+		},
+		{
+			id:     8,
+			name:   "m",
+			before: map[*server]int{{Host: "a"}: 1, {Host: "a"}: 1},
+			after:  map[*server]int{{Host: "a"}: 1, {Host: "a"}: 1},
+			want:   nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		got := diffValues(tc.name, flatten(tc.before), flatten(tc.after))
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("\nTEST %d\ngot:  %q\nwant: %q", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestD verifies that D() prefixes the changed paths with the name of the
+// argument at the call site.
+func TestD(t *testing.T) {
+	setTempDir(t)
+
+	before := server{Host: "localhost", Port: 80}
+	after := before
+	after.Port = 443
+	D(before, after)
+
+	log := readLog(t)
+	if want := colorize("after.Port", bold) + ": " + colorize("80", red) + " → " + colorize("443", green); !strings.Contains(log, want) {
+		t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+	}
+}
+
+// TestAsyncD verifies that D() finds the same differences in async mode as in
+// sync mode, even in elements that aren't printed because of MaxElements.
+func TestAsyncD(t *testing.T) {
+	setTempDir(t)
+	Async = true
+	t.Cleanup(func() {
+		Flush()
+		Async = false
+	})
+
+	before := make([]int, 2*MaxElements)
+	after := slices.Clone(before)
+	after[len(after)-1] = 1
+	D(before, after)
+	after[len(after)-1] = 2
+	Flush()
+
+	log := readLog(t)
+	path := "after[" + strconv.Itoa(len(after)-1) + "]"
+	if want := colorize(path, bold) + ": " + colorize("0", red) + " → " + colorize("1", green); !strings.Contains(log, want) {
+		t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+	}
+}
+
+// TestWatch verifies that Watch() prints a value in full the first time, and
+// only its changes after that.
+func TestWatch(t *testing.T) {
+	setTempDir(t)
+
+	srv := server{Host: "localhost", Port: 80}
+	for i := range 4 {
+		if i == 1 {
+			srv.Port = 443
+		}
+		Watch(srv)
+	}
+
+	log := readLog(t)
+	wants := []string{
+		colorize("srv", bold) + "=" + colorize(`q.server{Host:"localhost", Port:80}`, cyan),
+		colorize("srv.Port", bold) + ": " + colorize("80", red) + " → " + colorize("443", green),
+		colorize("srv", bold) + ": unchanged ×1",
+		colorize("srv", bold) + ": unchanged ×2",
+	}
+	for _, want := range wants {
+		if !strings.Contains(log, want) {
+			t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+		}
+	}
+}
+
+// TestWatchSameLine verifies that Watch() calls on the same line remember
+// their values separately, e.g. q.Watch(x); q.Watch(x+100).
+func TestWatchSameLine(t *testing.T) {
+	var buf bytes.Buffer
+	l := logger{sink: writerSink{w: &buf}}
+
+	// The calls are on the same line, but have different return addresses.
+	errNoNames := errors.New("no names")
+	first := caller{file: "main.go", line: 7, pc: 0x100, qFunc: "Watch"}
+	second := caller{file: "main.go", line: 7, pc: 0x108, qFunc: "Watch"}
+
+	x := 1
+	l.watch(first, errNoNames, x)
+	l.watch(second, errNoNames, x+100)
+	x = 2
+	l.watch(first, errNoNames, x)
+	l.watch(second, errNoNames, x+100)
+
+	got := buf.String()
+	wants := []string{
+		colorize("int(1)", cyan),
+		colorize("int(101)", cyan),
+		colorize("1", red) + " → " + colorize("2", green),
+		colorize("101", red) + " → " + colorize("102", green),
+	}
+	for _, want := range wants {
+		if !strings.Contains(got, want) {
+			t.Fatalf("\nlog:  %q\nmissing: %q", got, want)
+		}
+	}
+}
@@ -5,10 +5,15 @@ q.Q() is a fast way to pretty-print variables. It's easier than typing
 fmt.Printf("%#v", whatever). The output will be colorized and nicely formatted.
 The output goes to $TMPDIR/q, away from the noise of stdout.
 
-q exports a single Q() function. This is how you use it:
+q exports a Q() function. This is how you use it:
 
 	import "q"
 	...
 	q.Q(a, b, c)
+
+q.V() is like q.Q(), but it returns its argument, so it can wrap any
+expression in place:
+
+	return q.V(compute(x))
 */
 package q
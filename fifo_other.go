@@ -0,0 +1,17 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package q
+
+// openNonblock is added to the flags the log file is opened with. Only Unix
+// has named pipes that block until they have a reader.
+const openNonblock = 0
+
+// noReader returns true if err means that the named pipe being opened has no
+// reader.
+func noReader(error) bool {
+	return false
+}
@@ -0,0 +1,22 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package q
+
+import (
+	"errors"
+	"syscall"
+)
+
+// openNonblock is added to the flags the log file is opened with, so that
+// opening a named pipe doesn't wait for a reader.
+const openNonblock = syscall.O_NONBLOCK
+
+// noReader returns true if err means that the named pipe being opened has no
+// reader.
+func noReader(err error) bool {
+	return errors.Is(err, syscall.ENXIO)
+}
@@ -0,0 +1,510 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// formatIndent is the indentation of each level of an expanded value.
+	formatIndent = "    "
+
+	// minKeyWidth is the minimum width of the keys of an expanded struct or
+	// map, including the colon and the padding after it.
+	minKeyWidth = 4
+)
+
+// formatter pretty-prints values as Go syntax, e.g. []int{1, 2, 3}. Structs and
+// maps that contain other containers are expanded, one field per line.
+type formatter struct {
+	expand  bool            // print well-known types as structs, not in their human form
+	oneLine bool            // print containers on one line, even if they contain others
+	depth   int             // number of containers enclosing the current value
+	visited map[visit]bool  // pointers, maps, and slices on the current path
+	lengths map[uintptr]int // original lengths of slices and maps cut short by snapshot
+}
+
+// visit is a pointer, map, or slice being formatted. The type is needed to
+// tell apart a pointer to a struct and a pointer to its first field.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// entry is a single element of a container being formatted.
+type entry struct {
+	key   string // the field name or map key, followed by a colon. empty for slices
+	value string
+	more  bool // true if the entry is an elision marker, e.g. "… 9,990 more"
+}
+
+// format returns the pretty-printed form of the given value, the way q.Q()
+// prints it. Top-level strings aren't quoted.
+func format(v any) string {
+	v, lengths := unwrapSnapshot(v)
+	f := formatter{visited: map[visit]bool{}, lengths: lengths}
+
+	return f.value(addressable(reflect.ValueOf(v)), true, false)
+}
+
+// formatExpanded is like format, but well-known types, like time.Time, are
+// printed as the structs they are, instead of in their human form.
+func formatExpanded(v any) string {
+	v, lengths := unwrapSnapshot(v)
+	f := formatter{expand: true, visited: map[visit]bool{}, lengths: lengths}
+
+	return f.value(addressable(reflect.ValueOf(v)), true, false)
+}
+
+// addressable returns an addressable copy of v, so that the unexported fields
+// of a struct passed by value can be passed to custom formatters.
+func addressable(v reflect.Value) reflect.Value {
+	if !v.IsValid() || v.CanAddr() {
+		return v
+	}
+
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+
+	return c
+}
+
+// value returns the pretty-printed form of v. showType adds the type of v,
+// e.g. int(3) instead of 3, and quote quotes strings. Values with a custom
+// formatter are printed by it instead, with neither. Well-known standard
+// library types are printed in their human form, e.g. time.Duration(1.5s).
+// nolint: cyclop,gocyclo
+func (f *formatter) value(v reflect.Value, showType, quote bool) (s string) {
+	if s, ok := customFormat(v); ok {
+		return s
+	}
+	if !f.expand {
+		if s, ok := stdlibFormat(v, showType); ok {
+			return s
+		}
+	}
+
+	if v.IsValid() && v.CanInterface() {
+		if goStringer, ok := v.Interface().(fmt.GoStringer); ok {
+			defer func() {
+				if r := recover(); r != nil {
+					s = panicString(v, "GoString", r)
+				}
+			}()
+
+			return goStringer.GoString()
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return inline(v, strconv.FormatBool(v.Bool()), showType)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return inline(v, strconv.FormatInt(v.Int(), 10), showType)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return inline(v, strconv.FormatUint(v.Uint(), 10), showType)
+	case reflect.Uintptr:
+		return inline(v, fmt.Sprintf("%#x", v.Uint()), showType)
+	case reflect.Float32, reflect.Float64:
+		return inline(v, fmt.Sprintf("%#v", v.Float()), showType)
+	case reflect.Complex64, reflect.Complex128:
+		return fmt.Sprintf("%#v", v.Complex())
+	case reflect.String:
+		return formatString(v.String(), quote)
+	case reflect.Map:
+		if v.IsNil() {
+			return f.mapValue(v, showType)
+		}
+
+		return f.enter(v, "", func() string { return f.mapValue(v, showType) })
+	case reflect.Struct:
+		return f.structValue(v, showType)
+	case reflect.Interface:
+		if v.IsNil() {
+			return "nil"
+		}
+
+		return f.value(v.Elem(), showType, true)
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return f.bytesValue(v, showType)
+		}
+		if v.Kind() == reflect.Array {
+			return f.sliceValue(v, showType)
+		}
+		if v.IsNil() || v.Len() == 0 {
+			return f.sliceValue(v, showType)
+		}
+
+		return f.enter(v, "", func() string { return f.sliceValue(v, showType) })
+	case reflect.Pointer:
+		if v.IsNil() {
+			return "(" + v.Type().String() + ")(nil)"
+		}
+
+		return f.enter(v, "&", func() string { return "&" + f.value(v.Elem(), true, true) })
+	case reflect.Chan:
+		if showType {
+			return fmt.Sprintf("(%s)(%#v)", v.Type(), v.Pointer())
+		}
+
+		return fmt.Sprintf("%#v", v.Pointer())
+	case reflect.Func:
+		return v.Type().String() + " {...}"
+	case reflect.UnsafePointer:
+		return inline(v, fmt.Sprintf("%#x", v.Pointer()), showType)
+	default:
+		return "nil"
+	}
+}
+
+// enter formats the pointer, map, or slice v with the given function, unless
+// it's already being formatted further up, e.g. in a doubly-linked list. Then
+// a back-reference to it is returned instead, e.g. <cycle → &main.Node@0xc000…>.
+// prefix is added to the type in the back-reference.
+func (f *formatter) enter(v reflect.Value, prefix string, format func() string) string {
+	key := visit{ptr: v.Pointer(), typ: v.Type()}
+	if f.visited[key] {
+		typ := v.Type().String()
+		if v.Kind() == reflect.Pointer {
+			typ = v.Type().Elem().String()
+		}
+
+		return fmt.Sprintf("<cycle → %s%s@%#x>", prefix, typ, key.ptr)
+	}
+
+	f.visited[key] = true
+	defer delete(f.visited, key)
+
+	return format()
+}
+
+// mapValue formats a map, with its keys sorted.
+func (f *formatter) mapValue(v reflect.Value, showType bool) string {
+	t := v.Type()
+	typ := ""
+	if showType {
+		typ = t.String()
+	}
+	if v.IsNil() {
+		if showType {
+			return typ + "(nil)"
+		}
+
+		return "nil"
+	}
+	if v.Len() == 0 {
+		return typ + "{}"
+	}
+	if MaxDepth > 0 && f.depth >= MaxDepth {
+		return typ + "{…}"
+	}
+
+	f.depth++
+	defer func() { f.depth-- }()
+
+	keys := v.MapKeys()
+	slices.SortFunc(keys, compareKeys)
+	shown, more := f.limitElements(v)
+
+	entries := make([]entry, 0, shown+1)
+	for _, k := range keys[:shown] {
+		value := redacted(v.MapIndex(k))
+		if !redactKey(k) {
+			value = f.value(v.MapIndex(k), t.Elem().Kind() == reflect.Interface, true)
+		}
+		entries = append(entries, entry{key: f.value(k, false, true) + ":", value: value})
+	}
+	entries = appendMore(entries, more)
+
+	return layout(typ, entries, !f.oneLine && !canInline(t))
+}
+
+// structValue formats a struct, with its field names.
+func (f *formatter) structValue(v reflect.Value, showType bool) string {
+	t := v.Type()
+	typ := ""
+	if showType {
+		typ = t.String()
+	}
+	if !nonzero(v) {
+		return typ + "{}"
+	}
+	if MaxDepth > 0 && f.depth >= MaxDepth {
+		return typ + "{…}"
+	}
+
+	f.depth++
+	defer func() { f.depth-- }()
+
+	entries := make([]entry, 0, v.NumField())
+	for i := range v.NumField() {
+		field := t.Field(i)
+		value := redacted(v.Field(i))
+		if !redactField(field) {
+			value = f.value(fieldValue(v, i), labelType(field.Type), true)
+		}
+		entries = append(entries, entry{key: field.Name + ":", value: value})
+	}
+
+	return layout(typ, entries, !f.oneLine && !canInline(t))
+}
+
+// sliceValue formats a slice or an array.
+func (f *formatter) sliceValue(v reflect.Value, showType bool) string {
+	t := v.Type()
+	typ := ""
+	if showType {
+		typ = t.String()
+	}
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		if showType {
+			return typ + "(nil)"
+		}
+
+		return "nil"
+	}
+	if v.Len() == 0 {
+		return typ + "{}"
+	}
+	if MaxDepth > 0 && f.depth >= MaxDepth {
+		return typ + "{…}"
+	}
+
+	f.depth++
+	defer func() { f.depth-- }()
+
+	shown, more := f.limitElements(v)
+	entries := make([]entry, 0, shown+1)
+	for i := range shown {
+		entries = append(entries, entry{value: f.value(v.Index(i), t.Elem().Kind() == reflect.Interface, true)})
+	}
+	entries = appendMore(entries, more)
+
+	return layout(typ, entries, !f.oneLine && !canInline(t))
+}
+
+// layout joins the entries of a container, either on one line, e.g.
+// T{a:1, b:2}, or expanded, one entry per line. The keys of consecutive
+// expanded entries are padded so that their values line up. A multi-line value
+// ends the run of aligned keys.
+func layout(typ string, entries []entry, expand bool) string {
+	var b strings.Builder
+	b.WriteString(typ + "{")
+
+	if !expand {
+		for i, e := range entries {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(e.key + e.value)
+		}
+		b.WriteString("}")
+
+		return b.String()
+	}
+
+	b.WriteString("\n")
+	for start := 0; start < len(entries); {
+		end := start
+		for end < len(entries)-1 && !strings.Contains(entries[end].value, "\n") {
+			end++
+		}
+
+		width := minKeyWidth
+		for _, e := range entries[start : end+1] {
+			width = max(width, utf8.RuneCountInString(e.key)+1)
+		}
+
+		for _, e := range entries[start : end+1] {
+			b.WriteString(formatIndent)
+			if e.key != "" {
+				b.WriteString(e.key)
+				b.WriteString(strings.Repeat(" ", width-utf8.RuneCountInString(e.key)))
+			}
+			b.WriteString(strings.ReplaceAll(e.value, "\n", "\n"+formatIndent))
+			if !e.more {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		start = end + 1
+	}
+	b.WriteString("}")
+
+	return b.String()
+}
+
+// limitElements returns how many elements of the slice, array, or map v to
+// print, and how many are left out, according to MaxElements. Elements left
+// out of v by snapshot count as left out.
+func (f *formatter) limitElements(v reflect.Value) (shown, more int) {
+	n := originalLen(v, f.lengths)
+	shown = n
+	if MaxElements > 0 {
+		shown = min(shown, MaxElements)
+	}
+	shown = min(shown, v.Len())
+
+	return shown, n - shown
+}
+
+// appendMore adds an elision marker for the given number of left out elements,
+// e.g. "… 9,990 more", if there are any.
+func appendMore(entries []entry, more int) []entry {
+	if more == 0 {
+		return entries
+	}
+
+	return append(entries, entry{value: "… " + formatCount(more) + " more", more: true})
+}
+
+// formatString formats a string, quoted or not, cut short according to
+// MaxStringLen, e.g. "aaa"… 9,990 more bytes.
+func formatString(s string, quote bool) string {
+	more := ""
+	if MaxStringLen > 0 && len(s) > MaxStringLen {
+		n := MaxStringLen
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		more = "… " + formatCount(len(s)-n) + " more bytes"
+		s = s[:n]
+	}
+
+	if quote {
+		s = strconv.Quote(s)
+	}
+
+	return s + more
+}
+
+// formatCount formats a count with thousands separators, e.g. 9,990.
+func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+
+	return s
+}
+
+// truncateName cuts the given name short according to MaxNameLen, e.g.
+// compute(a, b, c) becomes compute(a, b….
+func truncateName(name string) string {
+	if MaxNameLen <= 0 || utf8.RuneCountInString(name) <= MaxNameLen {
+		return name
+	}
+
+	runes := []rune(name)
+
+	return string(runes[:max(MaxNameLen-1, 0)]) + "…"
+}
+
+// inline formats a number or a bool, given its string form, e.g. int(3) or
+// just 3.
+func inline(v reflect.Value, s string, showType bool) string {
+	if showType {
+		return v.Type().String() + "(" + s + ")"
+	}
+
+	return s
+}
+
+// panicString describes a panic in a method called while formatting v.
+func panicString(v reflect.Value, method string, r any) string {
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		return "(" + v.Type().String() + ")(nil)"
+	}
+
+	return fmt.Sprintf("(%s)(PANIC=calling method %q: %v)", v.Type(), method, r)
+}
+
+// canInline returns true if values of the given type are printed on one line.
+func canInline(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Map:
+		return !canExpand(t.Elem())
+	case reflect.Struct:
+		for i := range t.NumField() {
+			if canExpand(t.Field(i).Type) {
+				return false
+			}
+		}
+
+		return true
+	case reflect.Array, reflect.Slice:
+		return !canExpand(t.Elem())
+	case reflect.Interface, reflect.Pointer, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return false
+	default:
+		return true
+	}
+}
+
+// canExpand returns true if values of the given type may contain other values.
+// Values with a custom formatter or a human form are treated as single values.
+func canExpand(t reflect.Type) bool {
+	if hasCustomFormat(t) || (humanTypes[t] && t != reflect.TypeFor[http.Header]()) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Map, reflect.Struct, reflect.Interface, reflect.Array, reflect.Slice, reflect.Pointer:
+		return true
+	default:
+		return false
+	}
+}
+
+// labelType returns true if struct fields of the given type are printed with
+// their type.
+func labelType(t reflect.Type) bool {
+	return t.Kind() == reflect.Interface || t.Kind() == reflect.Struct
+}
+
+// fieldValue returns the ith field of the struct v. Non-nil interfaces are
+// replaced by the values inside them.
+func fieldValue(v reflect.Value, i int) reflect.Value {
+	field := v.Field(i)
+	if field.Kind() == reflect.Interface && !field.IsNil() {
+		return field.Elem()
+	}
+
+	return field
+}
+
+// nonzero returns true if v isn't the zero value of its type.
+func nonzero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if nonzero(fieldValue(v, i)) {
+				return true
+			}
+		}
+
+		return false
+	case reflect.Array:
+		for i := range v.Len() {
+			if nonzero(v.Index(i)) {
+				return true
+			}
+		}
+
+		return false
+	case reflect.Invalid:
+		return false
+	default:
+		return !v.IsZero()
+	}
+}
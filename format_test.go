@@ -0,0 +1,241 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// nolint: gochecknoglobals
+var update = flag.Bool("update", false, "update the golden files")
+
+// listNode is a node in a doubly-linked list, for testing cycle detection.
+type listNode struct {
+	Value      int
+	Prev, Next *listNode
+}
+
+// treeNode is a node in a tree with parent pointers, for testing cycle
+// detection.
+type treeNode struct {
+	Name     string
+	Parent   *treeNode
+	Children []*treeNode
+}
+
+// account is a struct with a mix of field types, for testing the layout of
+// expanded structs.
+type account struct {
+	ID       int
+	Owner    string
+	Balance  float64
+	Tags     []string
+	Limits   map[string]uint16
+	Err      error
+	private  bool
+	Callback func(int) error
+}
+
+// point is a comparable struct, for testing the order of struct map keys.
+type point struct {
+	X, Y int
+}
+
+// TestFormatGolden verifies the output of format() against the golden files in
+// testdata/format. Run go test -update to regenerate them.
+func TestFormatGolden(t *testing.T) {
+	list := &listNode{Value: 1}
+	list.Next = &listNode{Value: 2, Prev: list}
+	list.Next.Next = &listNode{Value: 3, Prev: list.Next}
+
+	root := &treeNode{Name: "root"}
+	root.Children = []*treeNode{{Name: "a", Parent: root}, {Name: "b", Parent: root}}
+
+	selfMap := map[string]any{"name": "self"}
+	selfMap["self"] = selfMap
+
+	selfSlice := []any{1, nil}
+	selfSlice[1] = selfSlice
+
+	testCases := []struct {
+		name string
+		v    any
+	}{
+		{name: "scalars", v: []any{1, uint16(443), 3.14, true, "hello", 'q', complex(1, 2), nil}},
+		{name: "nil_values", v: []any{(*int)(nil), []int(nil), map[string]int(nil), error(nil)}},
+		{name: "struct", v: account{
+			ID:      7,
+			Owner:   "ryboe",
+			Balance: 12.5,
+			Tags:    []string{"admin", "beta"},
+			Limits:  map[string]uint16{"daily": 100, "monthly": 2000},
+			Err:     errors.New("overdrawn"),
+			private: true,
+		}},
+		{name: "nested_slices", v: [][]int{{1, 2}, {3}, {}}},
+		{name: "map_of_structs", v: map[string]account{"a": {ID: 1}, "b": {ID: 2, Tags: []string{"x"}}}},
+		{name: "doubly_linked_list", v: list},
+		{name: "parent_pointers", v: root},
+		{name: "self_map", v: selfMap},
+		{name: "self_slice", v: selfSlice},
+		{name: "interface_keys", v: map[any]int{1: 1, 2: 2, 3: 3, 4: 4, "a": 5, "b": 6, true: 7, 2.5: 8, nil: 9}},
+		{name: "struct_keys", v: map[point]string{{X: 3, Y: 4}: "c", {X: 1, Y: 2}: "a", {X: 1, Y: 3}: "b"}},
+		{name: "array_keys", v: map[[2]int]bool{{2, 1}: true, {1, 2}: false, {1, 1}: true}},
+		{name: "shared_pointer", v: func() any {
+			shared := &listNode{Value: 1}
+
+			return []*listNode{shared, shared}
+		}()},
+	}
+
+	addr := regexp.MustCompile(`@0x[0-9a-f]+`)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := addr.ReplaceAllString(format(tc.v), "@0x…") + "\n"
+			golden := filepath.Join("testdata", "format", tc.name+".golden")
+
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != string(want) {
+				t.Fatalf("\nformat(%s)\ngot:\n%s\nwant:\n%s", tc.name, got, want)
+			}
+		})
+	}
+}
+
+// setLimits sets the truncation limits for the duration of the test.
+func setLimits(t *testing.T, elements, stringLen, depth, nameLen int) {
+	t.Helper()
+
+	old := [4]int{MaxElements, MaxStringLen, MaxDepth, MaxNameLen}
+	MaxElements, MaxStringLen, MaxDepth, MaxNameLen = elements, stringLen, depth, nameLen
+	t.Cleanup(func() {
+		MaxElements, MaxStringLen, MaxDepth, MaxNameLen = old[0], old[1], old[2], old[3]
+	})
+}
+
+// TestFormatLimits verifies that format() cuts long and deep values short, with
+// a marker saying how much was left out.
+func TestFormatLimits(t *testing.T) {
+	setLimits(t, 3, 5, 2, 0)
+
+	type node struct {
+		Name string
+		Next *node
+	}
+
+	testCases := []struct {
+		id   int
+		v    any
+		want string
+	}{
+		{
+			id:   1,
+			v:    make([]int, 10000),
+			want: "[]int{0, 0, 0, … 9,997 more}",
+		},
+		{
+			id:   2,
+			v:    []int{1, 2, 3},
+			want: "[]int{1, 2, 3}",
+		},
+		{
+			id:   3,
+			v:    map[int]bool{1: true, 2: false, 3: true, 4: false, 5: true},
+			want: "map[int]bool{1:true, 2:false, 3:true, … 2 more}",
+		},
+		{
+			id:   4,
+			v:    [][]int{{1}, {2}, {3}, {4}},
+			want: "[][]int{\n    {1},\n    {2},\n    {3},\n    … 1 more\n}",
+		},
+		{
+			id:   5,
+			v:    "hello world",
+			want: "hello… 6 more bytes",
+		},
+		{
+			id:   6,
+			v:    []string{"hello world"},
+			want: `[]string{"hello"… 6 more bytes}`,
+		},
+		{
+			id:   7,
+			v:    "abcdé",
+			want: "abcd… 2 more bytes",
+		},
+		{
+			id:   8,
+			v:    [][][]int{{{1}}},
+			want: "[][][]int{\n    {\n        {…},\n    },\n}",
+		},
+		{
+			id:   9,
+			v:    node{Name: "a", Next: &node{Name: "b", Next: &node{Name: "c"}}},
+			want: "q.node{\n    Name: \"a\",\n    Next: &q.node{\n        Name: \"b\",\n        Next: &q.node{…},\n    },\n}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := format(tc.v); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:\n%s\nwant:\n%s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatCount verifies that formatCount() adds thousands separators.
+func TestFormatCount(t *testing.T) {
+	testCases := []struct {
+		n    int
+		want string
+	}{
+		{n: 0, want: "0"},
+		{n: 999, want: "999"},
+		{n: 1000, want: "1,000"},
+		{n: 9990, want: "9,990"},
+		{n: 1234567, want: "1,234,567"},
+	}
+
+	for _, tc := range testCases {
+		if got := formatCount(tc.n); got != tc.want {
+			t.Fatalf("\nformatCount(%d)\ngot:  %s\nwant: %s", tc.n, got, tc.want)
+		}
+	}
+}
+
+// TestTruncateName verifies that long argument names are cut short.
+func TestTruncateName(t *testing.T) {
+	setLimits(t, 0, 0, 0, 10)
+
+	name := "compute(alpha, beta)"
+	got := prependArgName([]string{name}, []string{"1"})[0]
+	want := colorize("compute(a…", bold) + "=1"
+	if got != want {
+		t.Fatalf("\nprependArgName(%q)\ngot:  %q\nwant: %q", name, got, want)
+	}
+
+	if got := truncateName("short"); got != "short" {
+		t.Fatalf("\ntruncateName(%q)\ngot:  %q\nwant: %q", "short", got, "short")
+	}
+
+	if got := truncateName(strings.Repeat("é", 11)); got != strings.Repeat("é", 9)+"…" {
+		t.Fatalf("\ntruncateName(%q)\ngot:  %q\nwant: %q", strings.Repeat("é", 11), got, strings.Repeat("é", 9)+"…")
+	}
+}
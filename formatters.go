@@ -0,0 +1,147 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// QFormatter is implemented by types that know how to print themselves in the
+// q log. QFormat is used instead of the default pretty-printed form wherever a
+// value of the type appears, including inside other values, e.g. as a struct
+// field or a slice element.
+type QFormatter interface {
+	QFormat() string
+}
+
+// formatterRegistry holds the formatters added with RegisterFormatter. It is
+// safe for concurrent use.
+type formatterRegistry struct {
+	mu         sync.RWMutex
+	byType     map[reflect.Type]func(reflect.Value) string
+	interfaces []reflect.Type // registered interface types, in registration order
+}
+
+// nolint: gochecknoglobals
+var (
+	formatters formatterRegistry
+
+	qFormatterType = reflect.TypeFor[QFormatter]()
+)
+
+// RegisterFormatter makes q print values of type T with the given function,
+// wherever they appear, including inside other values, e.g. as a struct field
+// or a slice element. It's useful for types that print badly, like big
+// matrices or IDs wrapped in structs, and for types from other packages, which
+// can't implement QFormatter. If T is an interface, the function is used for
+// every type that implements it, unless that type has a formatter of its own.
+// Registering another formatter for the same type replaces the first one.
+func RegisterFormatter[T any](format func(T) string) {
+	t := reflect.TypeFor[T]()
+
+	formatters.mu.Lock()
+	defer formatters.mu.Unlock()
+
+	if formatters.byType == nil {
+		formatters.byType = map[reflect.Type]func(reflect.Value) string{}
+	}
+	if _, ok := formatters.byType[t]; !ok && t.Kind() == reflect.Interface {
+		formatters.interfaces = append(formatters.interfaces, t)
+	}
+
+	formatters.byType[t] = func(v reflect.Value) string {
+		// v may be a concrete type that implements the interface T, so it
+		// must be converted before the type assertion.
+		return format(v.Convert(t).Interface().(T)) // nolint: forcetypeassert
+	}
+}
+
+// lookup returns the registered formatter for the given type, if there is one.
+func (r *formatterRegistry) lookup(t reflect.Type) (func(reflect.Value) string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if format, ok := r.byType[t]; ok {
+		return format, true
+	}
+
+	for _, iface := range r.interfaces {
+		if t.Implements(iface) {
+			return r.byType[iface], true
+		}
+	}
+
+	return nil, false
+}
+
+// customFormat formats v with its registered formatter, or its QFormat method.
+// It returns false if v has neither, or it can't be passed to them.
+func customFormat(v reflect.Value) (s string, ok bool) {
+	if !v.IsValid() || v.Kind() == reflect.Interface {
+		// Interfaces are formatted by the value inside them.
+		return "", false
+	}
+
+	format, registered := formatters.lookup(v.Type())
+	if !registered {
+		switch {
+		case v.Type().Implements(qFormatterType):
+			format = callQFormat
+		case v.CanAddr() && reflect.PointerTo(v.Type()).Implements(qFormatterType):
+			format = func(v reflect.Value) string { return callQFormat(v.Addr()) }
+		default:
+			return "", false
+		}
+	}
+
+	v, ok = exported(v)
+	if !ok {
+		return "", false
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			s, ok = panicString(v, "QFormat", r), true
+		}
+	}()
+
+	return format(v), true
+}
+
+// hasCustomFormat returns true if values of the given type have a registered
+// formatter or a QFormat method.
+func hasCustomFormat(t reflect.Type) bool {
+	if t.Kind() == reflect.Interface {
+		return false
+	}
+
+	if _, ok := formatters.lookup(t); ok {
+		return true
+	}
+
+	return t.Implements(qFormatterType) || reflect.PointerTo(t).Implements(qFormatterType)
+}
+
+// callQFormat calls the QFormat method of v, which must implement QFormatter.
+func callQFormat(v reflect.Value) string {
+	return v.Interface().(QFormatter).QFormat() // nolint: forcetypeassert
+}
+
+// exported returns v in a form that can be passed to Interface(). Values read
+// from unexported struct fields can't be, unless they're addressable, in which
+// case the field is read through a pointer to it.
+func exported(v reflect.Value) (reflect.Value, bool) {
+	if v.CanInterface() {
+		return v, true
+	}
+
+	if !v.CanAddr() {
+		return reflect.Value{}, false
+	}
+
+	return reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem(), true // nolint: gosec
+}
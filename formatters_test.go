@@ -0,0 +1,120 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// userID is an ID wrapped in a struct, which prints badly by default.
+type userID struct{ n int }
+
+// matrix formats itself with a value receiver.
+type matrix [2][2]float64
+
+func (m matrix) QFormat() string {
+	return fmt.Sprintf("matrix[%v %v; %v %v]", m[0][0], m[0][1], m[1][0], m[1][1])
+}
+
+// celsius formats itself with a pointer receiver.
+type celsius float64
+
+func (c *celsius) QFormat() string {
+	return fmt.Sprintf("%.1f°C", float64(*c))
+}
+
+// panicky has a QFormat method that panics.
+type panicky struct{}
+
+func (panicky) QFormat() string {
+	panic("boom")
+}
+
+// shape is an interface with a registered formatter.
+type shape interface{ area() float64 }
+
+type square struct{ side float64 }
+
+func (s square) area() float64 { return s.side * s.side }
+
+// registerFormatter registers a formatter for the duration of the test.
+func registerFormatter[T any](t *testing.T, format func(T) string) {
+	t.Helper()
+
+	RegisterFormatter(format)
+	t.Cleanup(func() {
+		formatters.mu.Lock()
+		defer formatters.mu.Unlock()
+
+		typ := reflect.TypeFor[T]()
+		delete(formatters.byType, typ)
+		formatters.interfaces = slices.DeleteFunc(formatters.interfaces, func(iface reflect.Type) bool {
+			return iface == typ
+		})
+	})
+}
+
+// TestCustomFormatters verifies that registered formatters and QFormat methods
+// are used wherever a value of the type appears.
+func TestCustomFormatters(t *testing.T) {
+	registerFormatter(t, func(id userID) string { return fmt.Sprintf("user#%d", id.n) })
+	registerFormatter(t, func(s shape) string { return fmt.Sprintf("shape(area=%v)", s.area()) })
+
+	type account struct {
+		Owner   userID
+		Friends []userID
+		temp    celsius
+		Shape   shape
+	}
+
+	temp := celsius(21.5)
+	testCases := []struct {
+		id   int
+		v    any
+		want string
+	}{
+		{id: 1, v: userID{n: 7}, want: "user#7"},
+		{id: 2, v: &userID{n: 7}, want: "&user#7"},
+		{id: 3, v: []userID{{1}, {2}}, want: "[]q.userID{user#1, user#2}"},
+		{id: 4, v: map[string]userID{"me": {3}}, want: `map[string]q.userID{"me":user#3}`},
+		{id: 5, v: matrix{{1, 0}, {0, 1}}, want: "matrix[1 0; 0 1]"},
+		{id: 6, v: &temp, want: "21.5°C"},
+		{id: 7, v: square{side: 2}, want: "shape(area=4)"},
+		{id: 8, v: panicky{}, want: `(q.panicky)(PANIC=calling method "QFormat": boom)`},
+		{
+			id: 9,
+			v:  account{Owner: userID{1}, Friends: []userID{{2}}, temp: 20, Shape: square{side: 3}},
+			want: "q.account{\n" +
+				"    Owner:   user#1,\n" +
+				"    Friends: {user#2},\n" +
+				"    temp:    20.0°C,\n" +
+				"    Shape:   shape(area=9),\n" +
+				"}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := format(tc.v); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:\n%s\nwant:\n%s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestCustomFormattersDiff verifies that D() compares values with a custom
+// formatter by their formatted form.
+func TestCustomFormattersDiff(t *testing.T) {
+	registerFormatter(t, func(id userID) string { return fmt.Sprintf("user#%d", id.n) })
+
+	type session struct{ User userID }
+
+	got := diffValues("s", flatten(session{User: userID{1}}), flatten(session{User: userID{2}}))
+	want := []string{diffLine("s", ".User", "user#1", "user#2")}
+	if !slices.Equal(got, want) {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+}
@@ -1,10 +1,3 @@
 module github.com/ryboe/q
 
 go 1.26.0
-
-require github.com/kr/pretty v0.3.1
-
-require (
-	github.com/kr/text v0.2.0 // indirect
-	github.com/rogpeppe/go-internal v1.14.1 // indirect
-)
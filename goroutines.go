@@ -0,0 +1,217 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// goroutine is a single goroutine parsed from the output of runtime.Stack().
+type goroutine struct {
+	id      int64
+	state   string // e.g. "chan receive"
+	minutes int    // how long the goroutine has been waiting
+	frames  []stackFrame
+}
+
+// stackFrame is a single function call in a goroutine's stack.
+type stackFrame struct {
+	funcName string // e.g. main.worker, without the arguments
+	file     string
+	line     int
+	creator  bool // true if this is the "created by" frame
+}
+
+// goroutineGroup is a set of goroutines with identical stacks and states.
+type goroutineGroup struct {
+	goroutines []goroutine
+	minMinutes int
+	maxMinutes int
+}
+
+// allStacks returns the stack traces of all goroutines, as formatted by
+// runtime.Stack().
+func allStacks() []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// parseGoroutines parses the output of runtime.Stack() into goroutines. Lines
+// it doesn't understand are skipped.
+func parseGoroutines(stacks []byte) []goroutine {
+	var goroutines []goroutine
+	var g *goroutine
+	var frame *stackFrame
+
+	scanner := bufio.NewScanner(bytes.NewReader(stacks))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "goroutine "):
+			goroutines = append(goroutines, parseGoroutineHeader(line))
+			g = &goroutines[len(goroutines)-1]
+			frame = nil
+		case g == nil, line == "":
+			continue
+		case strings.HasPrefix(line, "\t"):
+			// "\t/path/to/file.go:12 +0x1d" is the location of the previous
+			// function line.
+			if frame == nil {
+				continue
+			}
+			frame.file, frame.line = parseFileLine(strings.TrimSpace(line))
+			g.frames = append(g.frames, *frame)
+			frame = nil
+		case strings.HasPrefix(line, "created by "):
+			// "created by main.main in goroutine 1"
+			funcName := strings.TrimPrefix(line, "created by ")
+			if i := strings.Index(funcName, " in goroutine "); i >= 0 {
+				funcName = funcName[:i]
+			}
+			frame = &stackFrame{funcName: funcName, creator: true}
+		default:
+			// "main.worker(0xc000012345, 0x1)"
+			funcName := line
+			if i := strings.LastIndexByte(funcName, '('); i > 0 {
+				funcName = funcName[:i]
+			}
+			frame = &stackFrame{funcName: funcName}
+		}
+	}
+
+	return goroutines
+}
+
+// parseGoroutineHeader parses the first line of a goroutine's stack trace,
+// e.g. "goroutine 7 [chan receive, 4 minutes]:".
+func parseGoroutineHeader(line string) goroutine {
+	var g goroutine
+
+	fields := strings.Fields(line)
+	if len(fields) > 1 {
+		g.id, _ = strconv.ParseInt(fields[1], 10, 64)
+	}
+
+	start, end := strings.IndexByte(line, '['), strings.LastIndexByte(line, ']')
+	if start < 0 || end < start {
+		return g
+	}
+
+	var state []string
+	for part := range strings.SplitSeq(line[start+1:end], ", ") {
+		if minutes, found := strings.CutSuffix(part, " minutes"); found {
+			g.minutes, _ = strconv.Atoi(minutes)
+
+			continue
+		}
+		state = append(state, part)
+	}
+	g.state = strings.Join(state, ", ")
+
+	return g
+}
+
+// parseFileLine parses the location of a stack frame, e.g.
+// "/path/to/file.go:12 +0x1d".
+func parseFileLine(s string) (file string, line int) {
+	if i := strings.LastIndex(s, " +0x"); i >= 0 {
+		s = s[:i]
+	}
+
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return s, 0
+	}
+	line, _ = strconv.Atoi(s[i+1:])
+
+	return s[:i], line
+}
+
+// groupGoroutines groups goroutines that are in the same state with identical
+// stacks. The biggest groups come first.
+func groupGoroutines(goroutines []goroutine) []goroutineGroup {
+	var groups []goroutineGroup
+	index := map[string]int{} // group key -> index in groups
+	for _, g := range goroutines {
+		key := g.state + "\n" + fmt.Sprint(g.frames)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, goroutineGroup{minMinutes: g.minutes, maxMinutes: g.minutes})
+		}
+
+		group := &groups[i]
+		group.goroutines = append(group.goroutines, g)
+		group.minMinutes = min(group.minMinutes, g.minutes)
+		group.maxMinutes = max(group.maxMinutes, g.minutes)
+	}
+
+	slices.SortStableFunc(groups, func(a, b goroutineGroup) int {
+		return cmp.Compare(len(b.goroutines), len(a.goroutines))
+	})
+
+	return groups
+}
+
+// formatGoroutineGroups returns the groups, one frame per line, each group
+// headed by its size and state, e.g. "4 goroutines [chan receive, 4m]". Frames
+// in the runtime and q packages are omitted.
+func formatGoroutineGroups(groups []goroutineGroup) string {
+	var lines []string
+	for i, group := range groups {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, group.header())
+
+		for _, frame := range group.goroutines[0].frames {
+			if isHiddenFrame(frame.funcName, frame.file) {
+				continue
+			}
+
+			line := "    " + formatFrame(frame.funcName, frame.file, frame.line)
+			if frame.creator {
+				line = "    created by " + formatFrame(frame.funcName, frame.file, frame.line)
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// header returns a description of the group, e.g.
+// "4 goroutines [chan receive, 2-4m]" or "goroutine 1 [running]".
+func (group goroutineGroup) header() string {
+	title := fmt.Sprintf("%d goroutines", len(group.goroutines))
+	if len(group.goroutines) == 1 {
+		title = fmt.Sprintf("goroutine %d", group.goroutines[0].id)
+	}
+
+	state := group.goroutines[0].state
+	switch {
+	case group.maxMinutes == 0:
+	case group.minMinutes == group.maxMinutes:
+		state += fmt.Sprintf(", %dm", group.maxMinutes)
+	default:
+		state += fmt.Sprintf(", %d-%dm", group.minMinutes, group.maxMinutes)
+	}
+
+	return colorize(title, bold) + " [" + state + "]"
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleStacks = `goroutine 1 [running]:
+main.main()
+	/home/user/app/main.go:18 +0xdb
+
+goroutine 7 [chan receive, 4 minutes]:
+main.worker(0xc000012345)
+	/home/user/app/worker.go:12 +0x19
+created by main.main in goroutine 1
+	/home/user/app/main.go:12 +0x37
+
+goroutine 8 [chan receive, 2 minutes]:
+main.worker(0xc000012345)
+	/home/user/app/worker.go:12 +0x19
+created by main.main in goroutine 1
+	/home/user/app/main.go:12 +0x37
+
+goroutine 11 [sleep]:
+time.Sleep(0x34630b8a000)
+	/usr/local/go/src/runtime/time.go:368 +0x165
+main.poll()
+	/home/user/app/poll.go:15 +0x1d
+created by main.main in goroutine 1
+	/home/user/app/main.go:15 +0xa7
+`
+
+// TestParseGoroutines verifies that parseGoroutines() parses the IDs, states,
+// and stack frames in the output of runtime.Stack().
+func TestParseGoroutines(t *testing.T) {
+	goroutines := parseGoroutines([]byte(sampleStacks))
+	if len(goroutines) != 4 {
+		t.Fatalf("\ngot:  %d goroutines\nwant: 4 goroutines", len(goroutines))
+	}
+
+	got := goroutines[1]
+	want := goroutine{
+		id:      7,
+		state:   "chan receive",
+		minutes: 4,
+		frames: []stackFrame{
+			{funcName: "main.worker", file: "/home/user/app/worker.go", line: 12},
+			{funcName: "main.main", file: "/home/user/app/main.go", line: 12, creator: true},
+		},
+	}
+	if got.id != want.id || got.state != want.state || got.minutes != want.minutes || !slices.Equal(got.frames, want.frames) {
+		t.Fatalf("\ngot:  %+v\nwant: %+v", got, want)
+	}
+}
+
+// TestGroupGoroutines verifies that goroutines with identical stacks are
+// grouped together, with the biggest group first.
+func TestGroupGoroutines(t *testing.T) {
+	groups := groupGoroutines(parseGoroutines([]byte(sampleStacks)))
+	if len(groups) != 3 {
+		t.Fatalf("\ngot:  %d groups\nwant: 3 groups", len(groups))
+	}
+
+	wantHeaders := []string{
+		colorize("2 goroutines", bold) + " [chan receive, 2-4m]",
+		colorize("goroutine 1", bold) + " [running]",
+		colorize("goroutine 11", bold) + " [sleep]",
+	}
+	for i, want := range wantHeaders {
+		if got := groups[i].header(); got != want {
+			t.Fatalf("\ngroup %d\ngot:  %q\nwant: %q", i, got, want)
+		}
+	}
+
+	formatted := formatGoroutineGroups(groups)
+	if want := "    created by " + formatFrame("main.main", "/home/user/app/main.go", 12); !strings.Contains(formatted, want) {
+		t.Fatalf("\ngot:  %q\nmissing: %q", formatted, want)
+	}
+}
+
+// TestGoroutines verifies that Goroutines() logs the blocked goroutines of the
+// test.
+func TestGoroutines(t *testing.T) {
+	setTempDir(t)
+
+	block := make(chan struct{})
+	defer close(block)
+	for range 3 {
+		go func() { <-block }()
+	}
+
+	// The goroutines might not be blocked yet, so keep trying until they are.
+	want := colorize("3 goroutines", bold) + " [chan receive]"
+	for range 100 {
+		Goroutines()
+		if strings.Contains(readLog(t), want) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("\nlog:  %q\nmissing: %q", readLog(t), want)
+}
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,6 +21,8 @@ type color string
 const (
 	// ANSI color escape codes.
 	bold     color = "\033[1m"
+	red      color = "\033[31m"
+	green    color = "\033[32m"
 	yellow   color = "\033[33m"
 	cyan     color = "\033[36m"
 	endColor color = "\033[0m" // "reset everything"
@@ -27,15 +30,46 @@ const (
 	maxLineWidth = 80
 )
 
-// logger writes pretty logs to the $TMPDIR/q file. It takes care of opening and
-// closing the file. It is safe for concurrent use.
+// logger writes pretty logs to a sink, which is the $TMPDIR/q file by default.
+// It is safe for concurrent use.
 type logger struct {
 	mu        sync.Mutex   // protects all the other fields
-	buf       bytes.Buffer // collects writes before they're flushed to the log file
+	buf       bytes.Buffer // collects writes before they're flushed to the sink
+	sink      sink         // where the log is written. nil means the sink chosen by Q_OUTPUT
+	envFile   fileSink     // the file named by Q_OUTPUT, or $TMPDIR/q
 	start     time.Time    // time of first write in the current log group
 	lastWrite time.Time    // last time buffer was flushed. determines when to print header
 	lastFile  string       // last file to call q.Q(). determines when to print header
 	lastFunc  string       // last function to call q.Q(). determines when to print header
+	callTime  time.Time    // time of the q call being written. zero means now
+
+	watched map[watchKey]*watchState // last state of each q.Watch() argument
+
+	// The depth of q.Trace() calls is tracked by the calling goroutines, not
+	// when the lines are written, so that it stays right in async mode when
+	// a line is dropped.
+	traceMu    sync.Mutex    // protects traceDepth
+	traceDepth map[int64]int // number of active q.Trace() calls per goroutine
+
+	// The async queue isn't protected by mu, so that queueing a call never
+	// waits for a write.
+	queue      chan event   // calls waiting to be written in async mode
+	queueOnce  sync.Once    // starts the queue and its worker
+	queueReady atomic.Bool  // true once the queue is started
+	dropped    atomic.Int64 // calls dropped because the queue was full
+}
+
+// watchKey identifies a single argument of a q.Watch() call. Calls are told
+// apart by their return address, since there may be several on one line.
+type watchKey struct {
+	pc  uintptr // the return address of the call
+	arg int     // index of the argument in the call
+}
+
+// watchState is the state of a watched value the last time it was logged.
+type watchState struct {
+	entries   []diffEntry
+	unchanged int // number of calls in a row in which the value didn't change
 }
 
 // header returns a formatted header string, e.g. [14:00:36 main.go main.main:122]
@@ -46,7 +80,7 @@ func (l *logger) header(funcName, file string, line int) string {
 		return ""
 	}
 
-	now := time.Now().UTC()
+	now := l.now().UTC()
 	l.start = now
 	l.lastFunc = funcName
 	l.lastFile = file
@@ -67,37 +101,214 @@ func (l *logger) shouldPrintHeader(funcName, file string) bool {
 	// previous header.
 	const timeWindow = 2 * time.Second
 
-	return time.Since(l.lastWrite) > timeWindow
+	return l.now().Sub(l.lastWrite) > timeWindow
+}
+
+// now returns the time of the q call being written. In async mode, that's the
+// time the call was queued, not the time it's written.
+func (l *logger) now() time.Time {
+	if l.callTime.IsZero() {
+		return time.Now()
+	}
+
+	return l.callTime
+}
+
+// flush writes the logger's buffer to its sink.
+func (l *logger) flush() error {
+	defer func() {
+		l.buf.Reset()
+		l.lastWrite = l.now()
+	}()
+
+	s := l.sink
+	if s == nil {
+		s = l.envSink()
+	}
+
+	if err := s.write(l.buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to flush q buffer: %w", err)
+	}
+
+	return nil
 }
 
-// flush writes the logger's buffer to disk.
-func (l *logger) flush() (err error) {
-	path := filepath.Join(os.TempDir(), "q")
+// sink is a destination for the q log.
+type sink interface {
+	// write writes a batch of log lines.
+	write(p []byte) error
+
+	// close releases the resources held by the sink. Writers passed to
+	// SetOutput aren't closed, because q doesn't own them.
+	close() error
+}
+
+// fileSink appends to the file at path. The file is kept open between writes,
+// because opening it for every q.Q() call dominates the cost of logging in
+// tight loops. Before each write, the file at path is compared with the open
+// one, so that the log is reopened if it's deleted or rotated, e.g. by rmqq. An
+// empty path means $TMPDIR/q. Named pipes are written to like any other file,
+// once they have a reader.
+type fileSink struct {
+	path string
+
+	f    *os.File    // the open log file, or nil
+	name string      // the path f was opened at
+	info os.FileInfo // identifies the file f was opened at
+}
+
+func (s *fileSink) write(p []byte) error {
+	path := s.path
+	if path == "" {
+		path = defaultPath()
+	}
+
+	if s.f != nil && (s.name != path || !s.current()) {
+		s.close() // nolint: errcheck,gosec
+	}
+
+	if s.f == nil {
+		if err := s.open(path); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.f.Write(p); err != nil {
+		// The file may be a named pipe whose reader went away. Try again with
+		// a fresh file next time.
+		s.close() // nolint: errcheck,gosec
+
+		return err
+	}
+
+	return nil
+}
+
+// open opens the log file at path for appending, creating it if needed. Named
+// pipes are opened without waiting for a reader, which would block every q
+// call. If there's no reader yet, open fails, and the log is dropped until
+// there is one.
+func (s *fileSink) open(path string) error {
 	const userRW = 0o600
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, userRW)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY|openNonblock, userRW)
+	if noReader(err) {
+		return fmt.Errorf("named pipe %q has no reader: %w", path, err)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to open %q: %w", path, err)
 	}
-	defer func() {
-		if cerr := f.Close(); err == nil {
-			err = cerr
-		}
-		l.lastWrite = time.Now()
-	}()
 
-	_, err = io.Copy(f, &l.buf)
-	l.buf.Reset()
+	info, err := f.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to flush q buffer: %w", err)
+		f.Close() // nolint: errcheck,gosec
+
+		return fmt.Errorf("failed to stat %q: %w", path, err)
+	}
+
+	s.f, s.name, s.info = f, path, info
+
+	return nil
+}
+
+// current returns true if the open file is still the one at its path, i.e. it
+// hasn't been deleted, moved, or replaced.
+func (s *fileSink) current() bool {
+	info, err := os.Stat(s.name)
+
+	return err == nil && os.SameFile(info, s.info)
+}
+
+func (s *fileSink) close() error {
+	if s.f == nil {
+		return nil
 	}
 
+	err := s.f.Close()
+	s.f, s.name, s.info = nil, "", nil
+
+	return err
+}
+
+// writerSink writes to an io.Writer, e.g. os.Stderr.
+type writerSink struct {
+	w io.Writer
+}
+
+func (s writerSink) write(p []byte) error {
+	_, err := s.w.Write(p)
+
+	return err
+}
+
+func (writerSink) close() error {
 	return nil
 }
 
+// defaultPath returns the path of the default q log, $TMPDIR/q.
+func defaultPath() string {
+	return filepath.Join(os.TempDir(), "q")
+}
+
+// envSink returns the sink chosen by the Q_OUTPUT environment variable, which
+// is "stderr", "stdout", or the path of a file. If Q_OUTPUT is empty, it's the
+// $TMPDIR/q file. The environment is read on every call, but the file stays
+// open as long as the path doesn't change.
+func (l *logger) envSink() sink {
+	switch output := os.Getenv("Q_OUTPUT"); output {
+	case "stderr":
+		return writerSink{w: os.Stderr}
+	case "stdout":
+		return writerSink{w: os.Stdout}
+	default:
+		l.envFile.path = output
+
+		return &l.envFile
+	}
+}
+
+// SetOutput makes q write its log to w instead of the $TMPDIR/q file, e.g.
+// os.Stderr or a bytes.Buffer. Writes to w are serialized, so it doesn't need
+// to be safe for concurrent use. SetOutput(nil) restores the default, which is
+// the file named by the Q_OUTPUT environment variable, or $TMPDIR/q.
+func SetOutput(w io.Writer) {
+	var s sink
+	if w != nil {
+		s = writerSink{w: w}
+	}
+
+	std.setSink(s)
+}
+
+// SetOutputFile makes q append its log to the file at path instead of
+// $TMPDIR/q. The file is created if it doesn't exist, and it may be a named
+// pipe. q calls don't wait for a pipe to have a reader. Until it does, the log
+// is dropped. SetOutputFile("") restores the default, which is the file named
+// by the Q_OUTPUT environment variable, or $TMPDIR/q.
+func SetOutputFile(path string) {
+	var s sink
+	if path != "" {
+		s = &fileSink{path: path}
+	}
+
+	std.setSink(s)
+}
+
+// setSink replaces the logger's sink. A nil sink means the one chosen by
+// Q_OUTPUT.
+func (l *logger) setSink(s sink) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sink != nil {
+		l.sink.close() // nolint: errcheck,gosec
+	}
+	l.sink = s
+}
+
 // output writes to the log buffer. Each log message is prepended with a
 // timestamp. Long lines are broken at 80 characters.
 func (l *logger) output(args ...string) {
-	timestamp := fmt.Sprintf("%.3fs", time.Since(l.start).Seconds())
+	timestamp := fmt.Sprintf("%.3fs", l.now().Sub(l.start).Seconds())
 	timestampWidth := len(timestamp) + 1 // +1 for padding space after timestamp
 	timestamp = colorize(timestamp, yellow)
 
@@ -133,6 +344,16 @@ func (l *logger) output(args ...string) {
 	fmt.Fprint(&l.buf, "\n")
 }
 
+// shortFunc takes a fully qualified function name and returns just the
+// <package>.<function>, e.g. "github.com/ryboe/q.Q" becomes "q.Q".
+func shortFunc(funcName string) string {
+	if i := strings.LastIndex(funcName, "/"); i >= 0 {
+		return funcName[i+1:]
+	}
+
+	return funcName
+}
+
 // shortFile takes an absolute file path and returns just the <directory>/<file>,
 // e.g. "foo/bar.go".
 func shortFile(file string) string {
@@ -5,7 +5,10 @@
 package q
 
 import (
+	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -129,3 +132,182 @@ func TestOutput(t *testing.T) {
 		}
 	}
 }
+
+// TestSetOutput verifies that the log is written to the sink chosen by
+// Q_OUTPUT, SetOutputFile(), or SetOutput(), and to $TMPDIR/q by default.
+func TestSetOutput(t *testing.T) {
+	setTempDir(t)
+	t.Cleanup(func() { SetOutput(nil) })
+
+	dir := t.TempDir()
+	envPath := filepath.Join(dir, "env.log")
+	filePath := filepath.Join(dir, "file.log")
+	var buf bytes.Buffer
+
+	Q("default")
+	t.Setenv("Q_OUTPUT", envPath)
+	Q("env")
+	SetOutputFile(filePath)
+	Q("file")
+	SetOutput(&buf)
+	Q("writer")
+	SetOutput(nil)
+	Q("env again")
+
+	testCases := []struct {
+		path string
+		want []string
+		not  []string
+	}{
+		{path: filepath.Join(os.TempDir(), "q"), want: []string{"default"}, not: []string{"env"}},
+		{path: envPath, want: []string{"env", "env again"}, not: []string{"file", "writer"}},
+		{path: filePath, want: []string{"file"}, not: []string{"writer"}},
+	}
+
+	for _, tc := range testCases {
+		b, err := os.ReadFile(tc.path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", tc.path, err)
+		}
+
+		for _, want := range tc.want {
+			if !strings.Contains(string(b), want) {
+				t.Fatalf("\n%s:\n%s\nmissing: %s", tc.path, b, want)
+			}
+		}
+		for _, not := range tc.not {
+			if strings.Contains(string(b), not) {
+				t.Fatalf("\n%s:\n%s\nunexpected: %s", tc.path, b, not)
+			}
+		}
+	}
+
+	if got := buf.String(); !strings.Contains(got, "writer") || strings.Contains(got, "file") {
+		t.Fatalf("\nSetOutput(&buf)\ngot: %q", got)
+	}
+}
+
+// TestEnvSink verifies that Q_OUTPUT selects the right kind of sink.
+func TestEnvSink(t *testing.T) {
+	testCases := []struct {
+		output   string
+		want     sink
+		wantPath string
+	}{
+		{output: "", wantPath: ""},
+		{output: "stderr", want: writerSink{w: os.Stderr}},
+		{output: "stdout", want: writerSink{w: os.Stdout}},
+		{output: "/tmp/q.fifo", wantPath: "/tmp/q.fifo"},
+	}
+
+	var l logger
+	for _, tc := range testCases {
+		t.Setenv("Q_OUTPUT", tc.output)
+
+		got := l.envSink()
+		if tc.want == nil {
+			if f, ok := got.(*fileSink); !ok || f != &l.envFile || f.path != tc.wantPath {
+				t.Fatalf("\nQ_OUTPUT=%q\ngot:  %#v\nwant: &l.envFile with path %q", tc.output, got, tc.wantPath)
+			}
+
+			continue
+		}
+
+		if got != tc.want {
+			t.Fatalf("\nQ_OUTPUT=%q\ngot:  %#v\nwant: %#v", tc.output, got, tc.want)
+		}
+	}
+}
+
+// TestFileSinkReopen verifies that the file sink keeps the log open between
+// writes, and reopens it if it's deleted or rotated.
+func TestFileSinkReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "q")
+	s := &fileSink{path: path}
+	t.Cleanup(func() { s.close() }) // nolint: errcheck
+
+	write := func(line string) {
+		t.Helper()
+		if err := s.write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(path string) string {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(b)
+	}
+
+	write("one")
+	f := s.f
+	write("two")
+	if s.f != f {
+		t.Fatal("file was reopened, although it didn't change")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	write("three")
+	if got, want := read(path), "three\n"; got != want {
+		t.Fatalf("\nafter deleting the log\ngot:  %q\nwant: %q", got, want)
+	}
+
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	write("four")
+	if got, want := read(path), "four\n"; got != want {
+		t.Fatalf("\nafter rotating the log\ngot:  %q\nwant: %q", got, want)
+	}
+	if got, want := read(rotated), "three\n"; got != want {
+		t.Fatalf("\nrotated log\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+// BenchmarkFileSink measures the cost of writing a log line to a file that's
+// kept open, and to one that's reopened for every write, like q used to do.
+func BenchmarkFileSink(b *testing.B) {
+	line := []byte("0.000s x=int(42)\n")
+
+	b.Run("kept open", func(b *testing.B) {
+		s := &fileSink{path: filepath.Join(b.TempDir(), "q")}
+		defer s.close() // nolint: errcheck
+
+		for b.Loop() {
+			if err := s.write(line); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("reopened", func(b *testing.B) {
+		s := &fileSink{path: filepath.Join(b.TempDir(), "q")}
+
+		for b.Loop() {
+			if err := s.write(line); err != nil {
+				b.Fatal(err)
+			}
+			if err := s.close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkQ measures the cost of a q.Q() call in a loop, from finding the
+// argument names to writing the log file.
+func BenchmarkQ(b *testing.B) {
+	b.Setenv("TMPDIR", b.TempDir())
+	b.Cleanup(func() { std.envFile.close() }) // nolint: errcheck
+
+	x := 42
+	for b.Loop() {
+		Q(x)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package q
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestFileSinkNamedPipe verifies that writing to a named pipe doesn't wait for
+// a reader, and that the pipe is written to once it has one.
+func TestFileSinkNamedPipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "q")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skip("can't create a named pipe:", err)
+	}
+
+	s := &fileSink{path: path}
+	t.Cleanup(func() { s.close() }) // nolint: errcheck
+
+	if err := s.write([]byte("dropped\n")); !errors.Is(err, syscall.ENXIO) {
+		t.Fatalf("\nwrite without a reader\ngot:  %v\nwant: %v", err, syscall.ENXIO)
+	}
+
+	r, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := s.write([]byte("read\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf[:n]), "read\n"; got != want {
+		t.Fatalf("\nread from the pipe\ngot:  %q\nwant: %q", got, want)
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// printfFlags are the flag characters that package fmt understands.
+const printfFlags = "#0+- "
+
+// printfState holds the state of a single sprintf() call.
+type printfState struct {
+	buf     strings.Builder
+	names   []string // source text of the args
+	args    []any
+	argNum  int  // index of the next arg to be consumed
+	badArg  bool // true if an explicit arg index was invalid
+	usedArg []bool
+
+	// reordered is true if an explicit arg index was used. Unused args aren't
+	// reported then, like in package fmt.
+	reordered bool
+}
+
+// sprintf is like fmt.Sprintf, but it also understands the q-specific verbs
+// documented on Qf(). names holds the source text of each argument, as
+// returned by argNames().
+func sprintf(format string, names []string, args []any) string {
+	p := &printfState{
+		names:   names,
+		args:    args,
+		usedArg: make([]bool, len(args)),
+	}
+
+	for i := 0; i < len(format); {
+		if format[i] != '%' {
+			end := strings.IndexByte(format[i:], '%')
+			if end < 0 {
+				end = len(format) - i
+			}
+			p.buf.WriteString(format[i : i+end])
+			i += end
+
+			continue
+		}
+
+		i = p.directive(format, i+1)
+	}
+
+	p.extraArgs()
+
+	return p.buf.String()
+}
+
+// directive formats a single %-directive, starting just after the %. It
+// returns the position in format just after the directive.
+// nolint: cyclop,gocyclo
+func (p *printfState) directive(format string, i int) int {
+	start := i
+	for i < len(format) && strings.IndexByte(printfFlags, format[i]) >= 0 {
+		i++
+	}
+	flags := format[start:i]
+
+	// Widths and precisions given by * consume an arg, which is passed to
+	// fmt along with the value.
+	var starArgs []any
+	var width, precision string
+	p.badArg = false
+	i = p.argIndex(format, i)
+	if i < len(format) && format[i] == '*' {
+		i++
+		starArgs = append(starArgs, p.nextArg())
+		width = "*"
+	} else {
+		start = i
+		for i < len(format) && isDigit(format[i]) {
+			i++
+		}
+		width = format[start:i]
+	}
+
+	if i < len(format) && format[i] == '.' {
+		i++
+		i = p.argIndex(format, i)
+		if i < len(format) && format[i] == '*' {
+			i++
+			starArgs = append(starArgs, p.nextArg())
+			precision = ".*"
+		} else {
+			start = i
+			for i < len(format) && isDigit(format[i]) {
+				i++
+			}
+			precision = "." + format[start:i]
+		}
+	}
+	i = p.argIndex(format, i)
+
+	if i >= len(format) {
+		p.buf.WriteString("%!(NOVERB)")
+
+		return i
+	}
+
+	verb, size := utf8.DecodeRuneInString(format[i:])
+	i += size
+
+	switch {
+	case verb == '%':
+		p.buf.WriteByte('%')
+
+		return i
+	case p.badArg:
+		fmt.Fprintf(&p.buf, "%%!%c(BADINDEX)", verb)
+
+		return i
+	case p.argNum >= len(p.args):
+		fmt.Fprintf(&p.buf, "%%!%c(MISSING)", verb)
+
+		return i
+	}
+
+	n := p.argNum
+	arg := p.nextArg()
+	spec := "%" + flags + width + precision
+
+	switch verb {
+	case 'n':
+		name := p.name(n)
+		if name == "" {
+			// Literals don't have names, so print the value instead.
+			fmt.Fprintf(&p.buf, spec+"v", append(starArgs, arg)...)
+
+			break
+		}
+		name = fmt.Sprintf(spec+"s", append(starArgs, name)...)
+		p.buf.WriteString(colorize(name, bold))
+	case 'Q':
+		value := formatArgs(arg)
+		if strings.Contains(flags, "+") {
+			value = prependArgName([]string{p.name(n)}, value)
+		}
+		p.buf.WriteString(value[0])
+	default:
+		if s, ok := redactedArg(arg); ok && verb != 'T' && verb != 'p' {
+			// The width, precision, and flags are meant for the value, not
+			// for q's form of it.
+			p.buf.WriteString(s)
+
+			break
+		}
+		arg, _ = unwrapSnapshot(arg)
+		fmt.Fprintf(&p.buf, spec+string(verb), append(starArgs, arg)...)
+	}
+
+	return i
+}
+
+// argIndex parses an explicit argument index, e.g. [2], if there is one at
+// position i in format. It returns the position just after the index.
+func (p *printfState) argIndex(format string, i int) int {
+	if i >= len(format) || format[i] != '[' {
+		return i
+	}
+
+	p.reordered = true
+	end := strings.IndexByte(format[i:], ']')
+	if end < 0 {
+		p.badArg = true
+
+		return len(format)
+	}
+
+	n, err := strconv.Atoi(format[i+1 : i+end])
+	if err != nil || n < 1 || n > len(p.args) {
+		p.badArg = true
+	} else {
+		p.argNum = n - 1
+	}
+
+	return i + end + 1
+}
+
+// extraArgs reports args that were not consumed by any verb, the same way
+// package fmt does, e.g. %!(EXTRA int=3).
+func (p *printfState) extraArgs() {
+	if p.reordered {
+		return
+	}
+
+	var extra []string
+	for i, used := range p.usedArg {
+		if used {
+			continue
+		}
+		if p.args[i] == nil {
+			extra = append(extra, "<nil>")
+
+			continue
+		}
+		arg, _ := unwrapSnapshot(p.args[i])
+		value := arg
+		if s, ok := redactedArg(p.args[i]); ok {
+			value = s
+		}
+		extra = append(extra, fmt.Sprintf("%T=%v", arg, value))
+	}
+
+	if len(extra) > 0 {
+		fmt.Fprintf(&p.buf, "%%!(EXTRA %s)", strings.Join(extra, ", "))
+	}
+}
+
+// redactedArg returns the form of arg that q.Q() prints, if arg has sensitive
+// fields or map entries, which are redacted in it. fmt doesn't know which
+// fields are sensitive, so it can't be given such values. Errors and Stringers
+// are left to fmt, which prints them with their own methods. Snapshots that
+// were cut short are printed the same way, since fmt can't tell.
+func redactedArg(arg any) (string, bool) {
+	switch arg.(type) {
+	case truncatedSnapshot:
+		return format(arg), true
+	case error, fmt.Stringer, fmt.Formatter:
+		return "", false
+	}
+
+	if !hasSecrets(reflect.ValueOf(arg)) {
+		return "", false
+	}
+
+	return format(arg), true
+}
+
+// name returns the source text of the nth arg, or an empty string if it
+// doesn't have one.
+func (p *printfState) name(n int) string {
+	if n < len(p.names) {
+		return p.names[n]
+	}
+
+	return ""
+}
+
+// nextArg consumes the next arg. It returns nil if there are no args left.
+func (p *printfState) nextArg() any {
+	if p.argNum >= len(p.args) {
+		return nil
+	}
+
+	arg := p.args[p.argNum]
+	p.usedArg[p.argNum] = true
+	p.argNum++
+
+	return arg
+}
+
+// isDigit returns true if the given byte is an ASCII digit.
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSprintf verifies that sprintf() formats standard fmt verbs the same way
+// fmt.Sprintf() does, and understands the q-specific verbs.
+// nolint: funlen
+func TestSprintf(t *testing.T) {
+	testCases := []struct {
+		id     int
+		format string
+		names  []string
+		args   []any
+		want   string
+	}{
+		{
+			id:     1,
+			format: "%d items in %s",
+			names:  []string{"n", "cart"},
+			args:   []any{3, "cart"},
+			want:   "3 items in cart",
+		},
+		{
+			id:     2,
+			format: "%5.2f|%-4d|%x|%T",
+			args:   []any{3.14159, 7, 255, uint16(1)},
+			want:   " 3.14|7   |ff|uint16",
+		},
+		{
+			id:     3,
+			format: "%*d|%.*f",
+			args:   []any{4, 7, 1, 2.55},
+			want:   "   7|2.5",
+		},
+		{
+			id:     4,
+			format: "100%%",
+			want:   "100%",
+		},
+		{
+			id:     5,
+			format: "%n is %v",
+			names:  []string{"port", ""},
+			args:   []any{443, 443},
+			want:   colorize("port", bold) + " is 443",
+		},
+		{
+			id:     6,
+			format: "%[1]n=%[1]v",
+			names:  []string{"port"},
+			args:   []any{443},
+			want:   colorize("port", bold) + "=443",
+		},
+		{
+			id:     7,
+			format: "%n",
+			names:  []string{""},
+			args:   []any{5},
+			want:   "5",
+		},
+		{
+			id:     8,
+			format: "got %Q",
+			names:  []string{"port"},
+			args:   []any{443},
+			want:   "got " + colorize("int(443)", cyan),
+		},
+		{
+			id:     9,
+			format: "got %+Q",
+			names:  []string{"port"},
+			args:   []any{443},
+			want:   "got " + colorize("port", bold) + "=" + colorize("int(443)", cyan),
+		},
+		{
+			id:     10,
+			format: "%d %d",
+			args:   []any{1},
+			want:   "1 %!d(MISSING)",
+		},
+		{
+			id:     11,
+			format: "%d",
+			args:   []any{1, "two"},
+			want:   "1%!(EXTRA string=two)",
+		},
+		{
+			id:     12,
+			format: "%[3]d",
+			args:   []any{1},
+			want:   "%!d(BADINDEX)",
+		},
+		{
+			id:     13,
+			format: "%[1]d",
+			args:   []any{1, 2},
+			want:   "1",
+		},
+		{
+			id:     14,
+			format: "héllo %v wörld",
+			args:   []any{"ünïcode"},
+			want:   "héllo ünïcode wörld",
+		},
+	}
+
+	for _, tc := range testCases {
+		got := sprintf(tc.format, tc.names, tc.args)
+		if got != tc.want {
+			t.Fatalf("\nTEST %d\nsprintf(%q, %q, %v)\ngot:  %q\nwant: %q", tc.id, tc.format, tc.names, tc.args, got, tc.want)
+		}
+	}
+}
+
+// TestQf verifies that Qf() finds the names of its arguments at the call site.
+func TestQf(t *testing.T) {
+	setTempDir(t)
+
+	port := 443
+	Qf("listening on %+Q", port)
+
+	log := readLog(t)
+	if want := "listening on " + colorize("port", bold) + "=" + colorize("int(443)", cyan); !strings.Contains(log, want) {
+		t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+	}
+}
@@ -5,7 +5,11 @@
 package q
 
 import (
+	"cmp"
 	"fmt"
+	"reflect"
+	"strings"
+	"time"
 )
 
 // nolint: gochecknoglobals
@@ -22,26 +26,289 @@ var (
 	// builds that do include the additional debug output provided by `q.Q`.
 	// This also allows the consumer of the package to control what happens
 	// with leftover `q.Q` calls. Defaults to 2, because the user code calls
-	// q.Q(), which calls getCallerInfo().
+	// q.Q() (or q.V(), etc.), which calls getCallerInfo().
 	CallDepth = 2
+
+	// SourcePath lists extra directories to search for the source files of
+	// q.Q() calls, for binaries built with -trimpath or moved away from the
+	// machine they were built on. q needs the source files to print the
+	// argument names. A directory may be the root of a module or of a
+	// GOPATH-style tree. The directories in the Q_SOURCE_PATH environment
+	// variable, separated like $PATH, are searched too.
+	SourcePath []string
+
+	// ShowTypes adds the type of each argument to its name, e.g.
+	// port (uint16)=443. For q.V(), V2(), and V3(), it's the static type of
+	// the expression. The other functions take their arguments as
+	// interfaces, so they show the dynamic type, which differs from the
+	// static type only if the argument is itself an interface, like an error.
+	ShowTypes bool
+
+	// MaxElements is the maximum number of elements printed for each slice,
+	// array, or map. The rest are summarized, e.g. "… 9,990 more".
+	MaxElements = 100
+
+	// MaxStringLen is the maximum number of bytes printed for each string or
+	// byte slice.
+	MaxStringLen = 1000
+
+	// MaxDepth is the maximum number of nested slices, arrays, maps, and
+	// structs printed. Deeper values are abbreviated, e.g. T{…}.
+	MaxDepth = 10
+
+	// MaxNameLen is the maximum number of characters printed for each
+	// argument name, e.g. the source text of a long function call.
+	//
+	// Setting any of the limits to 0 removes it.
+	MaxNameLen = 60
 )
 
-// Q pretty-prints the given arguments to the $TMPDIR/q log file.
+// Q pretty-prints the given arguments to the $TMPDIR/q log file. If one of the
+// arguments is q.WithStack, the stack trace of the calling goroutine is printed
+// after the other arguments. If one of them is q.Expand, well-known types like
+// time.Time are printed as structs, instead of in their human form.
 func Q(v ...any) {
-	std.mu.Lock()
-	defer std.mu.Unlock()
+	c, err := getCallerInfo()
+
+	var stack []uintptr
+	if hasOption(v, WithStack) {
+		stack = callers()
+	}
+
+	formatValue := format
+	if hasOption(v, Expand) {
+		formatValue = formatExpanded
+	}
+
+	v = snapshotArgs(v)
+
+	std.emit(c, err, func(names []string) []string {
+		names, v := removeOptions(names, v)
+		args := prependArgName(withTypes(names, typesOf(v)), formatArgsWith(formatValue, v))
+		if stack != nil {
+			// Start the stack trace on its own line.
+			args = append(args, "\n"+formatStack(stack))
+		}
+
+		return args
+	})
+}
+
+// Stack prints the stack trace of the calling goroutine to the $TMPDIR/q log
+// file. Frames in the runtime and q packages are left out.
+func Stack() {
+	c, err := getCallerInfo()
+	stack := callers()
+	std.emit(c, err, func([]string) []string {
+		return []string{formatStack(stack)}
+	})
+}
+
+// V pretty-prints the given argument to the $TMPDIR/q log file and returns it
+// unchanged. It can wrap any expression in place, e.g. return q.V(compute(x)).
+func V[T any](v T) T {
+	c, err := getCallerInfo()
+	std.log(c, err, []reflect.Type{reflect.TypeFor[T]()}, v)
+
+	return v
+}
+
+// V2 is like V, but for expressions that produce two values, e.g.
+// n, err := q.V2(strconv.Atoi(s)).
+func V2[A, B any](a A, b B) (A, B) {
+	c, err := getCallerInfo()
+	std.log(c, err, []reflect.Type{reflect.TypeFor[A](), reflect.TypeFor[B]()}, a, b)
+
+	return a, b
+}
+
+// V3 is like V, but for expressions that produce three values.
+func V3[A, B, C any](x A, y B, z C) (A, B, C) {
+	c, err := getCallerInfo()
+	std.log(c, err, []reflect.Type{reflect.TypeFor[A](), reflect.TypeFor[B](), reflect.TypeFor[C]()}, x, y, z)
+
+	return x, y, z
+}
+
+// Qf formats its arguments according to a format specifier and writes the
+// resulting message to the $TMPDIR/q log file. In addition to the verbs
+// understood by package fmt, Qf understands these:
+//
+//	%n   the source text of the argument, e.g. port
+//	%Q   the argument, pretty-printed the way q.Q() would print it
+//	%+Q  like %Q, but prefixed with the source text, e.g. port=443
+//
+// Explicit argument indexes work with every verb, so q.Qf("%[1]n is %[1]v", x)
+// prints the name and the value of x.
+//
+// Sensitive struct fields and map entries are redacted with every verb.
+// Arguments that have any are printed the way %Q prints them, without the
+// color, and without the width, precision, and flags, unless they're errors or
+// Stringers.
+func Qf(format string, v ...any) {
+	c, err := getCallerInfo()
+	v = snapshotArgs(v)
+	std.emit(c, err, func(names []string) []string {
+		// The first name belongs to the format string.
+		if len(names) > 0 {
+			names = names[1:]
+		}
+
+		return []string{sprintf(format, names, v)}
+	})
+}
+
+// D prints the differences between two values to the $TMPDIR/q log file. Each
+// changed field is printed on its own line, e.g. cfg.Servers[2].Port: 80 → 443.
+// Pointers are followed, so D can compare a struct before and after it was
+// mutated through a pointer, as long as before is a copy, not the same pointer.
+func D(before, after any) {
+	c, err := getCallerInfo()
+	values := snapshotWholeArgs([]any{before, after})
+	before, after = values[0], values[1]
+	std.emit(c, err, func(names []string) []string {
+		// Prefix the paths with the name of the after argument, since that's
+		// the current state of the value.
+		name := ""
+		if len(names) == 2 {
+			name = cmp.Or(names[1], names[0])
+		}
 
-	// Flush the buffered writes to disk.
+		lines := diffValues(name, flatten(before), flatten(after))
+		if len(lines) == 0 {
+			lines = []string{summaryLine(name, "no differences")}
+		}
+
+		return []string{strings.Join(lines, "\n")}
+	})
+}
+
+// Watch is like Q, but it remembers the values logged at each call site. The
+// first time a Watch call runs, the values are printed in full. After that,
+// only the fields that changed since the last call are printed, or
+// "unchanged ×N" if nothing did. It's useful for logging a variable in a loop.
+func Watch(v ...any) {
+	c, err := getCallerInfo()
+	std.watch(c, err, v...)
+}
+
+// Goroutines prints the stack traces of all goroutines to the $TMPDIR/q log
+// file. Goroutines with identical stacks in the same state are grouped
+// together, and the biggest groups are printed first, e.g.
+// "4 goroutines [chan receive, 4m]". It's useful for finding deadlocks and
+// leaked goroutines.
+func Goroutines() {
+	c, err := getCallerInfo()
+	stacks := allStacks()
+	std.emit(c, err, func([]string) []string {
+		return []string{formatGoroutineGroups(groupGoroutines(parseGoroutines(stacks)))}
+	})
+}
+
+// Trace logs the entry to and exit from the function that calls it. It is meant
+// to be deferred, with the function's arguments:
+//
+//	func handle(req *Request, retries int) {
+//		defer q.Trace(req, retries)()
+//		...
+//	}
+//
+// On entry, it prints the function name and the arguments, e.g.
+// "→ main.handle(req=…, retries=3)". On exit, it prints the function name and
+// how long the call took, e.g. "← main.handle 12.3ms". Nested calls are
+// indented, separately for each goroutine.
+func Trace(v ...any) func() {
+	c, err := getCallerInfo()
+
+	return std.trace(c, err, v...)
+}
+
+// log pretty-prints the given values as name=value strings. types are the
+// static types of the values, shown if ShowTypes is set.
+func (l *logger) log(c caller, callerErr error, types []reflect.Type, v ...any) {
+	v = snapshotArgs(v)
+	l.emit(c, callerErr, func(names []string) []string {
+		// Convert the arguments to name=value strings.
+		return prependArgName(withTypes(names, types), formatArgs(v...))
+	})
+}
+
+// watch prints the given values, or how they changed since the last time they
+// were watched from the same call site.
+func (l *logger) watch(c caller, callerErr error, v ...any) {
+	v = snapshotWholeArgs(v)
+	l.emit(c, callerErr, func(names []string) []string {
+		if l.watched == nil {
+			l.watched = map[watchKey]*watchState{}
+		}
+
+		args := make([]string, len(v))
+		for i, value := range v {
+			name := ""
+			if i < len(names) {
+				name = names[i]
+			}
+
+			entries := flatten(value)
+			key := watchKey{pc: c.pc, arg: i}
+			last, seen := l.watched[key]
+			if !seen {
+				l.watched[key] = &watchState{entries: entries}
+				label := withTypes([]string{name}, typesOf([]any{value}))
+				args[i] = prependArgName(label, formatArgs(value))[0]
+
+				continue
+			}
+
+			lines := diffValues(name, last.entries, entries)
+			last.entries = entries
+			if len(lines) == 0 {
+				last.unchanged++
+				lines = []string{summaryLine(name, fmt.Sprintf("unchanged ×%d", last.unchanged))}
+			} else {
+				last.unchanged = 0
+			}
+			args[i] = strings.Join(lines, "\n")
+		}
+
+		return args
+	})
+}
+
+// emit writes a log line for the q function called by the given caller, or
+// queues it if Async is set. The caller info must be looked up by the exported
+// q function itself, so that CallDepth is the same for all of them. render
+// receives the source text of the arguments at the call site, and returns the
+// strings to output. If the caller info lookup failed (callerErr is non-nil),
+// or the source text couldn't be parsed, render receives nil.
+func (l *logger) emit(c caller, callerErr error, render func(names []string) []string) {
+	e := event{at: time.Now(), c: c, callerErr: callerErr, render: render}
+	if Async {
+		l.enqueue(e)
+
+		return
+	}
+
+	l.write(e)
+}
+
+// write writes the log line for a q call.
+func (l *logger) write(e event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.callTime = e.at
+
+	// Flush the buffered writes to the sink.
 	defer func() {
-		if err := std.flush(); err != nil {
+		if err := l.flush(); err != nil {
 			fmt.Println(err)
 		}
+		l.callTime = time.Time{}
 	}()
 
-	args := formatArgs(v...)
-	funcName, file, line, err := getCallerInfo()
-	if err != nil {
-		std.output(args...) // no name=value printing
+	if e.callerErr != nil {
+		l.output(e.render(nil)...) // no name=value printing
 
 		return
 	}
@@ -49,20 +316,18 @@ func Q(v ...any) {
 	// Print a header line if this q.Q() call is in a different file or
 	// function than the previous q.Q() call, or if the 2s timer expired.
 	// A header line looks like this: [14:00:36 main.go main.main:122].
-	header := std.header(funcName, file, line)
+	header := l.header(e.c.funcName, e.c.file, e.c.line)
 	if header != "" {
-		fmt.Fprint(&std.buf, "\n", header, "\n")
+		fmt.Fprint(&l.buf, "\n", header, "\n")
 	}
 
 	// q.Q(foo, bar, baz) -> []string{"foo", "bar", "baz"}
-	names, err := argNames(file, line)
+	names, err := argNames(e.c)
 	if err != nil {
-		std.output(args...) // no name=value printing
+		l.output(e.render(nil)...) // no name=value printing
 
 		return
 	}
 
-	// Convert the arguments to name=value strings.
-	args = prependArgName(names, args)
-	std.output(args...)
+	l.output(e.render(names)...)
 }
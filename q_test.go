@@ -0,0 +1,135 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readLog returns the contents of the q log file in the test's temp dir. It
+// must be paired with a call to setTempDir() at the top of the test.
+func readLog(t *testing.T) string {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join(os.TempDir(), "q"))
+	if err != nil {
+		t.Fatalf("failed to read q log: %v", err)
+	}
+
+	return string(b)
+}
+
+// setTempDir points $TMPDIR at a fresh directory, so the test doesn't write to
+// the real q log.
+func setTempDir(t *testing.T) {
+	t.Helper()
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Cleanup(func() {
+		std.mu.Lock()
+		defer std.mu.Unlock()
+		std.envFile.close() // nolint: errcheck,gosec
+	})
+}
+
+// TestV verifies that V() logs its argument and returns it unchanged.
+func TestV(t *testing.T) {
+	setTempDir(t)
+
+	x := 42
+	if got := V(x + 1); got != 43 {
+		t.Fatalf("\nV(x + 1)\ngot:  %d\nwant: %d", got, 43)
+	}
+
+	log := readLog(t)
+	if want := colorize("x + 1", bold) + "=" + colorize("int(43)", cyan); !strings.Contains(log, want) {
+		t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+	}
+}
+
+// TestV2 verifies that V2() can wrap a function returning (T, error).
+func TestV2(t *testing.T) {
+	setTempDir(t)
+
+	errBoom := errors.New("boom")
+	f := func() (string, error) { return "hello", errBoom }
+
+	s, err := V2(f())
+	if s != "hello" || !errors.Is(err, errBoom) {
+		t.Fatalf("\nV2(f())\ngot:  %q, %v\nwant: %q, %v", s, err, "hello", errBoom)
+	}
+
+	log := readLog(t)
+	if want := colorize("f()", bold) + "=" + colorize("hello", cyan); !strings.Contains(log, want) {
+		t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+	}
+}
+
+// TestShowTypes verifies that the static types of V2()'s arguments are logged
+// when ShowTypes is set, even if they're interfaces.
+func TestShowTypes(t *testing.T) {
+	setTempDir(t)
+	ShowTypes = true
+	t.Cleanup(func() { ShowTypes = false })
+
+	var port uint16 = 443
+	_, _ = V2(port, error(nil))
+
+	log := readLog(t)
+	for _, want := range []string{
+		colorize("port (uint16)", bold) + "=",
+		colorize("error(nil) (error)", bold) + "=",
+	} {
+		if !strings.Contains(log, want) {
+			t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+		}
+	}
+}
+
+// TestQMultiLine verifies that Q() finds the names of its arguments when the
+// call spans multiple lines.
+func TestQMultiLine(t *testing.T) {
+	setTempDir(t)
+
+	host, port := "localhost", 443
+	Q(
+		host,
+		port,
+	)
+
+	log := readLog(t)
+	for _, want := range []string{
+		colorize("host", bold) + "=" + colorize("localhost", cyan),
+		colorize("port", bold) + "=" + colorize("int(443)", cyan),
+	} {
+		if !strings.Contains(log, want) {
+			t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+		}
+	}
+}
+
+// TestVSameLine verifies that the names of several V() calls on the same line
+// aren't mixed up.
+func TestVSameLine(t *testing.T) {
+	setTempDir(t)
+
+	a, b := 1, 2
+	for range 2 {
+		_ = []int{V(a), V(b)}
+	}
+
+	log := readLog(t)
+	for _, want := range []string{
+		colorize("a", bold) + "=" + colorize("int(1)", cyan),
+		colorize("b", bold) + "=" + colorize("int(2)", cyan),
+	} {
+		if !strings.Contains(log, want) {
+			t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+		}
+	}
+}
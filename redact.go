@@ -0,0 +1,183 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// redactTag is the value of the q struct tag that marks a field as sensitive,
+// e.g. `q:"redact"`.
+const redactTag = "redact"
+
+// redactor decides which values are too sensitive to be logged. It is safe for
+// concurrent use.
+type redactor struct {
+	mu       sync.RWMutex
+	patterns []string // lowercase field names, matched anywhere in a name
+}
+
+// nolint: gochecknoglobals
+var redactions = redactor{patterns: []string{"password", "secret", "token"}}
+
+// Redact hides the values of struct fields and map entries whose names contain
+// one of the given patterns, ignoring case. Hidden values are printed as
+// <redacted len=32>, so that passwords and tokens don't end up in the q log if
+// q calls are left in a build. Fields named like password, secret, or token
+// are redacted by default, and so are fields tagged `q:"redact"`.
+func Redact(patterns ...string) {
+	redactions.mu.Lock()
+	defer redactions.mu.Unlock()
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern != "" && !slices.Contains(redactions.patterns, pattern) {
+			redactions.patterns = append(redactions.patterns, pattern)
+		}
+	}
+}
+
+// matches returns true if the given field name or map key contains one of the
+// redacted patterns.
+func (r *redactor) matches(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name = strings.ToLower(name)
+
+	return slices.ContainsFunc(r.patterns, func(pattern string) bool {
+		return strings.Contains(name, pattern)
+	})
+}
+
+// redactField returns true if the value of the given struct field must be
+// hidden.
+func redactField(field reflect.StructField) bool {
+	for option := range strings.SplitSeq(field.Tag.Get("q"), ",") {
+		if option == redactTag {
+			return true
+		}
+	}
+
+	return redactions.matches(field.Name)
+}
+
+// redactKey returns true if the value of the given map key must be hidden.
+// Only string keys are names.
+func redactKey(key reflect.Value) bool {
+	return key.Kind() == reflect.String && redactions.matches(key.String())
+}
+
+// redacted returns the placeholder printed in place of a sensitive value. The
+// length of strings, slices, and maps is kept, because it's useful for
+// debugging and it gives away little, e.g. <redacted len=32>.
+func redacted(v reflect.Value) string {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "<redacted>"
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return "<redacted len=" + strconv.Itoa(v.Len()) + ">"
+	default:
+		return "<redacted>"
+	}
+}
+
+// hasSecrets returns true if v contains a struct field or map entry that q.Q()
+// redacts. Values that are nested too deep to be printed are checked too,
+// since fmt prints everything.
+func hasSecrets(v reflect.Value) bool {
+	return hasSecretsSeen(v, map[visit]bool{})
+}
+
+// hasSecretsSeen is hasSecrets, given the pointers, maps, and slices checked
+// so far, so that cycles are checked once.
+// nolint: cyclop
+func hasSecretsSeen(v reflect.Value, seen map[visit]bool) bool {
+	if !v.IsValid() || !canHoldSecrets(v.Type()) {
+		return false
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		key := visit{ptr: v.Pointer(), typ: v.Type()}
+		if v.IsNil() || seen[key] {
+			return false
+		}
+		seen[key] = true
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		return hasSecretsSeen(v.Elem(), seen)
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if redactField(v.Type().Field(i)) || hasSecretsSeen(v.Field(i), seen) {
+				return true
+			}
+		}
+	case reflect.Map:
+		header := v.Type() == reflect.TypeFor[http.Header]()
+		for iter := v.MapRange(); iter.Next(); {
+			k := iter.Key()
+			if redactKey(k) || (header && isCredentialHeader(k.String())) {
+				return true
+			}
+			if hasSecretsSeen(k, seen) || hasSecretsSeen(iter.Value(), seen) {
+				return true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			if hasSecretsSeen(v.Index(i), seen) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// canHoldSecrets returns true if values of the given type can contain struct
+// fields or map entries that have to be redacted, so that values that can't
+// aren't walked.
+func canHoldSecrets(t reflect.Type) bool {
+	return canHoldSecretsSeen(t, map[reflect.Type]bool{})
+}
+
+func canHoldSecretsSeen(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Struct:
+		for i := range t.NumField() {
+			if redactField(t.Field(i)) || canHoldSecretsSeen(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+
+		return false
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String || canHoldSecretsSeen(t.Key(), seen) || canHoldSecretsSeen(t.Elem(), seen)
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return canHoldSecretsSeen(t.Elem(), seen)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestRedact verifies that sensitive fields and map entries are hidden by the
+// formatter.
+func TestRedact(t *testing.T) {
+	type credentials struct {
+		User     string
+		Password string
+		APIToken []byte
+		PIN      int    `q:"redact"`
+		Note     string `json:"note" q:"redact"`
+		hostKey  *string
+	}
+
+	key := "ssh-ed25519 AAAA"
+	testCases := []struct {
+		id   int
+		v    any
+		want string
+	}{
+		{
+			id: 1,
+			v:  credentials{User: "admin", Password: "hunter2", APIToken: make([]byte, 32), PIN: 1234, Note: "n"},
+			want: "q.credentials{\n" +
+				"    User:     \"admin\",\n" +
+				"    Password: <redacted len=7>,\n" +
+				"    APIToken: <redacted len=32>,\n" +
+				"    PIN:      <redacted>,\n" +
+				"    Note:     <redacted len=1>,\n" +
+				"    hostKey:  (*string)(nil),\n" +
+				"}",
+		},
+		{
+			id:   2,
+			v:    map[string]string{"user": "admin", "db_password": "hunter2", "Secret-Key": "abc"},
+			want: `map[string]string{"Secret-Key":<redacted len=3>, "db_password":<redacted len=7>, "user":"admin"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := format(tc.v); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:\n%s\nwant:\n%s", tc.id, got, tc.want)
+		}
+	}
+
+	// Registered patterns apply to unexported fields too.
+	Redact("HostKey")
+	t.Cleanup(func() {
+		redactions.mu.Lock()
+		defer redactions.mu.Unlock()
+		redactions.patterns = slices.DeleteFunc(redactions.patterns, func(p string) bool { return p == "hostkey" })
+	})
+
+	got := format(credentials{hostKey: &key})
+	if want := "hostKey:  <redacted len=16>"; !strings.Contains(got, want) {
+		t.Fatalf("\nformat(credentials{hostKey: &key})\ngot:\n%s\nmissing: %s", got, want)
+	}
+}
+
+// TestRedactDiff verifies that D() doesn't print the values of sensitive
+// fields either.
+func TestRedactDiff(t *testing.T) {
+	type login struct {
+		User     string
+		Password string
+	}
+
+	got := diffValues("l", flatten(login{User: "a", Password: "old"}), flatten(login{User: "a", Password: "newer"}))
+	want := []string{diffLine("l", ".Password", "<redacted len=3>", "<redacted len=5>")}
+	if !slices.Equal(got, want) {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+
+}
+
+// TestRedactQf verifies that Qf() doesn't print the values of sensitive fields
+// with fmt verbs either.
+func TestRedactQf(t *testing.T) {
+	type config struct {
+		User     string
+		Password string
+	}
+
+	cfg := config{User: "admin", Password: "hunter2"}
+	formats := []string{"%v", "%+v", "%#v", "%s", "%d", "%v %v", "%[1]v", "%08.3v"}
+	args := []any{cfg, &cfg, []config{cfg}, map[string]string{"password": "hunter2"}}
+
+	for _, format := range formats {
+		for _, arg := range args {
+			got := sprintf(format, nil, []any{arg})
+			if strings.Contains(got, "hunter2") || !strings.Contains(got, "<redacted len=7>") {
+				t.Fatalf("\nsprintf(%q, %#v)\ngot:  %q\nwant: the password redacted", format, arg, got)
+			}
+		}
+	}
+
+	// Extra args are redacted too, and errors are printed by fmt as usual.
+	got := sprintf("%v", nil, []any{errors.New("boom"), cfg})
+	if want := `boom%!(EXTRA q.config=q.config{User:"admin", Password:<redacted len=7>})`; got != want {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+
+	// Values without sensitive fields are printed by fmt, verbs and all.
+	testCases := []struct {
+		format string
+		arg    any
+		want   string
+	}{
+		{format: "%08.3f", arg: struct{ F float64 }{3.14159}, want: "{0003.142}"},
+		{format: "%x", arg: map[string]int{"a": 255}, want: "map[61:ff]"},
+		{format: "%v", arg: []any{1, "a"}, want: "[1 a]"},
+		{format: "%+v", arg: &server{Host: "a", Port: 80}, want: "&{Host:a Port:80}"},
+	}
+
+	for _, tc := range testCases {
+		if got := sprintf(tc.format, nil, []any{tc.arg}); got != tc.want {
+			t.Fatalf("\nsprintf(%q, %#v)\ngot:  %q\nwant: %q", tc.format, tc.arg, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// nolint: gochecknoglobals
+var (
+	mutexType   = reflect.TypeFor[sync.Mutex]()
+	rwMutexType = reflect.TypeFor[sync.RWMutex]()
+)
+
+// snapshotArgs returns deep copies of the arguments of a q call that's going
+// to be written later, in async mode, so that the log shows the values as they
+// were when q was called, even if they're changed before they're formatted. In
+// sync mode, the arguments are formatted right away, so v is returned as is.
+func snapshotArgs(v []any) []any {
+	return snapshotEach(v, snapshot)
+}
+
+// snapshotWholeArgs is like snapshotArgs, but the copies are whole, regardless
+// of MaxElements, MaxStringLen, and MaxDepth. q.D() and q.Watch() compare every
+// element of their arguments, not only the printed ones, so they find the same
+// differences in async mode as in sync mode.
+func snapshotWholeArgs(v []any) []any {
+	return snapshotEach(v, wholeSnapshot)
+}
+
+// snapshotEach returns the snapshots of v in async mode, or v in sync mode.
+func snapshotEach(v []any, snap func(any) any) []any {
+	if !Async {
+		return v
+	}
+
+	copies := make([]any, len(v))
+	for i, x := range v {
+		copies[i] = snap(x)
+	}
+
+	return copies
+}
+
+// snapshot returns a deep copy of x, as much of it as will be printed:
+//
+//   - Pointers point to copies of their targets. Pointers to the same value,
+//     and cycles, are kept that way in the copy.
+//   - Slices get a copy of their first MaxElements elements, or MaxStringLen
+//     bytes. The capacity of the copy is its length, and slices that
+//     overlapped don't anymore.
+//   - Maps get a copy of their first MaxElements entries, in the order they're
+//     printed.
+//   - Arrays get a copy of their first MaxElements elements, or MaxStringLen
+//     bytes. The rest are zero.
+//   - Structs get a copy of all their fields, including unexported ones.
+//     Mutexes are left unlocked, so that formatters that lock them don't
+//     deadlock.
+//   - Channels, functions, and unsafe pointers are shared, since they can't
+//     be copied.
+//   - Values printed in their human form, like time.Time, or with a custom
+//     formatter are copied whole, but the pointers in them are shared, since
+//     some of them are compared by address, like the *time.Location of
+//     time.Local.
+//
+// Values nested deeper than MaxDepth aren't printed, so they're shared too. If
+// slices or maps were cut short, the copy is returned in a truncatedSnapshot,
+// so that their original lengths can still be printed, e.g. "… 9,990 more".
+func snapshot(x any) any {
+	if x == nil {
+		return nil
+	}
+
+	c := copier{pointers: map[copyKey]reflect.Value{}}
+	v := c.copy(reflect.ValueOf(x)).Interface()
+	if c.lengths != nil {
+		return truncatedSnapshot{v: v, lengths: c.lengths}
+	}
+
+	return v
+}
+
+// wholeSnapshot is like snapshot, but it copies all of x, no matter how much of
+// it is printed. Only channels, functions, and unsafe pointers are shared.
+func wholeSnapshot(x any) any {
+	if x == nil {
+		return nil
+	}
+
+	c := copier{whole: true, pointers: map[copyKey]reflect.Value{}}
+
+	return c.copy(reflect.ValueOf(x)).Interface()
+}
+
+// truncatedSnapshot is a snapshot with slices or maps that were cut short.
+type truncatedSnapshot struct {
+	v       any
+	lengths map[uintptr]int // original lengths of the cut copies, by Pointer()
+}
+
+// unwrapSnapshot returns the value in x, if it's a truncatedSnapshot, and the
+// original lengths of the slices and maps in it that were cut short.
+func unwrapSnapshot(x any) (any, map[uintptr]int) {
+	if s, ok := x.(truncatedSnapshot); ok {
+		return s.v, s.lengths
+	}
+
+	return x, nil
+}
+
+// originalLen returns the length of the slice or map v before it was cut short
+// by snapshot, given the lengths recorded in a truncatedSnapshot.
+func originalLen(v reflect.Value, lengths map[uintptr]int) int {
+	if k := v.Kind(); k == reflect.Slice || k == reflect.Map {
+		if n, ok := lengths[v.Pointer()]; ok {
+			return n
+		}
+	}
+
+	return v.Len()
+}
+
+// copier makes deep copies of values.
+type copier struct {
+	whole    bool                      // copy all of the value, not only the printed part
+	inLeaf   bool                      // copying a value printed as a whole, so share its pointers
+	depth    int                       // number of containers enclosing the current value
+	pointers map[copyKey]reflect.Value // copies of the pointers, slices, and maps seen so far
+	lengths  map[uintptr]int           // original lengths of the copies that were cut short
+}
+
+// copyKey identifies a pointer, slice, or map that has been copied. Slices of
+// different lengths that start at the same element are different values.
+type copyKey struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// keyOf returns the copyKey of the pointer, slice, or map v.
+func keyOf(v reflect.Value) copyKey {
+	key := copyKey{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+
+	return key
+}
+
+// copy returns a deep copy of v, which can be set and passed to Interface().
+// nolint: cyclop
+func (c *copier) copy(v reflect.Value) reflect.Value {
+	dst := reflect.New(v.Type()).Elem()
+
+	// Values read from unexported fields can't be copied, unless they're
+	// addressable. Structs and arrays are made addressable, so that their
+	// fields and elements are.
+	v, ok := exported(v)
+	if !ok {
+		return dst
+	}
+	if k := v.Kind(); k == reflect.Struct || k == reflect.Array {
+		v = addressable(v)
+	}
+	if !c.inLeaf && isLeaf(v.Type()) {
+		return c.copyLeaf(v)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map:
+		if v.IsNil() || c.tooDeep() || (c.inLeaf && v.Kind() == reflect.Pointer) {
+			dst.Set(v)
+
+			return dst
+		}
+		if p, ok := c.pointers[keyOf(v)]; ok {
+			dst.Set(p)
+
+			return dst
+		}
+	case reflect.Array:
+		if c.tooDeep() {
+			dst.Set(v)
+
+			return dst
+		}
+	case reflect.Struct:
+		if v.Type() == mutexType || v.Type() == rwMutexType {
+			return dst
+		}
+		if c.tooDeep() {
+			dst.Set(v)
+
+			return dst
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		p := reflect.New(v.Type().Elem())
+		c.pointers[keyOf(v)] = p
+		p.Elem().Set(c.copy(v.Elem()))
+		dst.Set(p)
+	case reflect.Slice:
+		shown := c.shownLen(v)
+		s := reflect.MakeSlice(v.Type(), shown, shown)
+		c.pointers[keyOf(v)] = s
+		c.copyElems(s, v, shown)
+		c.cut(s, v.Len())
+		dst.Set(s)
+	case reflect.Array:
+		c.copyElems(dst, v, c.shownLen(v))
+	case reflect.Map:
+		shown := c.shownLen(v)
+		m := reflect.MakeMapWithSize(v.Type(), shown)
+		c.pointers[keyOf(v)] = m
+		c.copyMap(m, v, shown)
+		c.cut(m, v.Len())
+		dst.Set(m)
+	case reflect.Struct:
+		c.copyFields(dst, v)
+	case reflect.Interface:
+		if !v.IsNil() {
+			dst.Set(c.copy(v.Elem()))
+		}
+	default:
+		// Basic values are copied by Set. Strings are immutable, and channels,
+		// functions, and unsafe pointers are shared.
+		dst.Set(v)
+	}
+
+	return dst
+}
+
+// copyLeaf returns a copy of v, a value that's printed as a whole. All of it is
+// copied, regardless of the limits, but the pointers in it are shared.
+func (c *copier) copyLeaf(v reflect.Value) reflect.Value {
+	whole := c.whole
+	c.whole, c.inLeaf = true, true
+	defer func() { c.whole, c.inLeaf = whole, false }()
+
+	return c.copy(v)
+}
+
+// isLeaf returns true if values of the given type are printed as a whole, in
+// their human form or with a custom formatter. Pointers to them aren't, since
+// the pointer is printed too.
+func isLeaf(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		return false
+	}
+
+	return humanTypes[t] || hasCustomFormat(t)
+}
+
+// copyElems copies the first n elements of the slice or array v to dst.
+func (c *copier) copyElems(dst, v reflect.Value, n int) {
+	if v.Type().Elem().Kind() == reflect.Uint8 {
+		// Bytes have nothing to copy deeply.
+		reflect.Copy(dst, v.Slice(0, n))
+
+		return
+	}
+
+	c.depth++
+	defer func() { c.depth-- }()
+
+	for i := range n {
+		dst.Index(i).Set(c.copy(v.Index(i)))
+	}
+}
+
+// copyMap copies the first n entries of the map v to dst, in the order they're
+// printed.
+func (c *copier) copyMap(dst, v reflect.Value, n int) {
+	c.depth++
+	defer func() { c.depth-- }()
+
+	if n == v.Len() {
+		for iter := v.MapRange(); iter.Next(); {
+			dst.SetMapIndex(c.copy(iter.Key()), c.copy(iter.Value()))
+		}
+
+		return
+	}
+
+	for _, k := range firstKeys(v, n) {
+		// Keys that aren't equal to themselves, like NaN, can't be looked up.
+		if value := v.MapIndex(k); value.IsValid() {
+			dst.SetMapIndex(c.copy(k), c.copy(value))
+		}
+	}
+}
+
+// cut records the original length of the slice or map copy, if it was cut
+// short.
+func (c *copier) cut(dup reflect.Value, n int) {
+	if dup.Len() == n {
+		return
+	}
+
+	if c.lengths == nil {
+		c.lengths = map[uintptr]int{}
+	}
+	c.lengths[dup.Pointer()] = n
+}
+
+// shownLen returns the number of elements of the slice, array, or map v to
+// copy: all of them if the copy is whole, or the ones that are printed.
+func (c *copier) shownLen(v reflect.Value) int {
+	if c.whole {
+		return v.Len()
+	}
+
+	return shownLen(v)
+}
+
+// shownLen returns the number of elements of the slice, array, or map v that
+// are printed: at most MaxStringLen bytes, or MaxElements other elements.
+func shownLen(v reflect.Value) int {
+	limit := MaxElements
+	if v.Kind() != reflect.Map && v.Type().Elem().Kind() == reflect.Uint8 {
+		limit = MaxStringLen
+	}
+
+	if limit > 0 {
+		return min(v.Len(), limit)
+	}
+
+	return v.Len()
+}
+
+// firstKeys returns the first n keys of the map v, in the order they're
+// printed, without sorting all of them.
+func firstKeys(v reflect.Value, n int) []reflect.Value {
+	keys := make([]reflect.Value, 0, n+1)
+	for iter := v.MapRange(); iter.Next(); {
+		k := iter.Key()
+		i, _ := slices.BinarySearchFunc(keys, k, compareKeys)
+		if i == n {
+			continue
+		}
+
+		keys = slices.Insert(keys, i, k)
+		if len(keys) > n {
+			keys = keys[:n]
+		}
+	}
+
+	return keys
+}
+
+// copyFields copies the fields of the struct v to dst, which must be
+// addressable.
+func (c *copier) copyFields(dst, v reflect.Value) {
+	c.depth++
+	defer func() { c.depth-- }()
+
+	for i := range v.NumField() {
+		field, _ := exported(dst.Field(i))
+		field.Set(c.copy(v.Field(i)))
+	}
+}
+
+// tooDeep returns true if the current value is nested too deep to be printed,
+// and the copy isn't whole.
+func (c *copier) tooDeep() bool {
+	return !c.whole && MaxDepth > 0 && c.depth > MaxDepth
+}
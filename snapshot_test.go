@@ -0,0 +1,210 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"math/big"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// inventory has fields of every kind that snapshot copies.
+type inventory struct {
+	Name   string
+	Counts map[string]int
+	Tags   []string
+	Owner  *string
+	items  [2]*int
+	Extra  any
+	mu     sync.Mutex
+}
+
+// TestSnapshot verifies that a snapshot is formatted like the original, and
+// isn't affected by later changes to it.
+func TestSnapshot(t *testing.T) {
+	owner, n := "ann", 1
+	inv := &inventory{
+		Name:   "shop",
+		Counts: map[string]int{"apples": 3},
+		Tags:   []string{"a", "b"},
+		Owner:  &owner,
+		items:  [2]*int{&n, &n},
+		Extra:  []byte("hi"),
+	}
+
+	mutations := []struct {
+		desc   string
+		mutate func()
+	}{
+		{desc: "string field", mutate: func() { inv.Name = "store" }},
+		{desc: "map entry", mutate: func() { inv.Counts["apples"] = 99 }},
+		{desc: "slice element", mutate: func() { inv.Tags[0] = "z" }},
+		{desc: "pointer target", mutate: func() { owner = "bob" }},
+		{desc: "unexported pointer target", mutate: func() { n = 2 }},
+		{desc: "value in interface", mutate: func() { inv.Extra.([]byte)[0] = 'H' }}, // nolint: forcetypeassert
+	}
+
+	for _, m := range mutations {
+		want := format(inv)
+		s := snapshot(inv)
+		if got := format(s); got != want {
+			t.Fatalf("\nformat(snapshot(inv))\ngot:\n%s\nwant:\n%s", got, want)
+		}
+
+		m.mutate()
+		if got := format(s); got != want {
+			t.Fatalf("\nsnapshot changed with the %s\ngot:\n%s\nwant:\n%s", m.desc, got, want)
+		}
+	}
+
+	// Pointers to the same value still are.
+	s := snapshot(inv).(*inventory) // nolint: forcetypeassert
+	if s.items[0] != s.items[1] || s.items[0] == &n {
+		t.Fatalf("\nsnapshot(inv).items = %v\nwant two copies of the same pointer", s.items)
+	}
+}
+
+// TestSnapshotCycle verifies that snapshot copies values that contain
+// themselves.
+func TestSnapshotCycle(t *testing.T) {
+	type node struct {
+		Val  int
+		Next *node
+	}
+
+	n := &node{Val: 1}
+	n.Next = n
+
+	s := snapshot(n).(*node) // nolint: forcetypeassert
+	if s == n || s.Next != s {
+		t.Fatalf("\nsnapshot(n) = %p, Next = %p\nwant a new node pointing to itself", s, s.Next)
+	}
+}
+
+// TestSnapshotMutex verifies that locked mutexes are copied unlocked, so that
+// formatting the snapshot can't deadlock.
+func TestSnapshotMutex(t *testing.T) {
+	inv := &inventory{Name: "shop"}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	s := snapshot(inv).(*inventory) // nolint: forcetypeassert
+	if !s.mu.TryLock() {
+		t.Fatal("snapshot(inv).mu is locked")
+	}
+}
+
+// TestSnapshotLeaves verifies that values printed in their human form are
+// copied whole, and that the pointers in them are shared, so they print like
+// the originals.
+func TestSnapshotLeaves(t *testing.T) {
+	setLimits(t, 3, 4, 1, 0)
+
+	now := time.Now()
+	if s := snapshot(now).(time.Time); s.Location() != now.Location() { // nolint: forcetypeassert
+		t.Fatalf("\nsnapshot(time.Now()).Location() = %p\nwant: %p", s.Location(), now.Location())
+	}
+
+	values := []any{
+		netip.MustParseAddr("1.2.3.4"),
+		new(big.Int).Lsh(big.NewInt(1), 500),
+		[]*big.Int{big.NewInt(1)},
+	}
+	for _, v := range values {
+		want := format(v)
+		if got := format(snapshot(v)); got != want {
+			t.Fatalf("\nformat(snapshot(%#v))\ngot:\n%s\nwant:\n%s", v, got, want)
+		}
+	}
+}
+
+// TestSnapshotLimits verifies that snapshot copies only as much of a value as
+// will be printed, and that the copy still prints like the original.
+func TestSnapshotLimits(t *testing.T) {
+	setLimits(t, 3, 4, 0, 0)
+
+	type batch struct {
+		IDs  []int
+		Data []byte
+	}
+
+	values := []any{
+		make([]int, 10000),
+		map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5},
+		[]byte("hello world"),
+		[]byte{0xff, 1, 2, 3, 4, 5},
+		[6]int{1, 2, 3, 4, 5, 6},
+		[][]int{{1, 2, 3, 4}, {5}, {6}, {7}},
+		&batch{IDs: []int{1, 2, 3, 4, 5}, Data: []byte("abcdef")},
+	}
+
+	for _, v := range values {
+		want := format(v)
+		if got := format(snapshot(v)); got != want {
+			t.Fatalf("\nformat(snapshot(%#v))\ngot:\n%s\nwant:\n%s", v, got, want)
+		}
+	}
+
+	// Only the printed elements are copied.
+	s, ok := snapshot(make([]int, 10000)).(truncatedSnapshot)
+	if !ok || len(s.v.([]int)) != 3 { // nolint: forcetypeassert
+		t.Fatalf("\nsnapshot(make([]int, 10000))\ngot:  %#v\nwant: a truncatedSnapshot of 3 elements", s)
+	}
+
+	// D() and Watch() copy all of their arguments, so they see changes that
+	// aren't printed.
+	if w, ok := wholeSnapshot(make([]int, 10000)).([]int); !ok || len(w) != 10000 {
+		t.Fatalf("\nwholeSnapshot(make([]int, 10000))\ngot:  %T of %d elements\nwant: all 10000 elements", w, len(w))
+	}
+
+	// fmt verbs can't print a cut copy, so it's printed the way %Q prints it.
+	if got, want := sprintf("%v", nil, []any{snapshot(make([]int, 5))}), "[]int{0, 0, 0, … 2 more}"; got != want {
+		t.Fatalf("\nsprintf(%%v)\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+// TestSnapshotArgs verifies that arguments are only copied in async mode.
+func TestSnapshotArgs(t *testing.T) {
+	m := map[string]int{"a": 1}
+
+	args := snapshotArgs([]any{m})
+	m["a"] = 2
+	if got := format(args[0]); !strings.Contains(got, `"a":2`) {
+		t.Fatalf("\nsync mode\ngot:  %s\nwant: the original map", got)
+	}
+
+	Async = true
+	t.Cleanup(func() { Async = false })
+
+	args = snapshotArgs([]any{m, nil})
+	m["a"] = 3
+	if got := format(args[0]); !strings.Contains(got, `"a":2`) || args[1] != nil {
+		t.Fatalf("\nasync mode\ngot:  %s, %v\nwant: a copy of the map, nil", got, args[1])
+	}
+}
+
+// TestAsyncSnapshot verifies that the log shows values as they were when q.Q()
+// was called in async mode, even if they were changed before being written.
+func TestAsyncSnapshot(t *testing.T) {
+	setTempDir(t)
+	Async = true
+	t.Cleanup(func() {
+		Flush()
+		Async = false
+	})
+
+	ports := []int{80}
+	Q(ports)
+	ports[0] = 443
+	Flush()
+
+	log := readLog(t)
+	if want := colorize("[]int{80}", cyan); !strings.Contains(log, want) {
+		t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+	}
+}
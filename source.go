@@ -0,0 +1,222 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"bufio"
+	"go/build"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sourcePathEnv is the environment variable that adds directories to
+// SourcePath, separated like $PATH.
+const sourcePathEnv = "Q_SOURCE_PATH"
+
+// sourceLocator finds the source files of q calls on disk. It is safe for
+// concurrent use.
+type sourceLocator struct {
+	mu    sync.Mutex
+	found map[string]string // path reported by runtime.Caller -> path on disk
+}
+
+// nolint: gochecknoglobals
+var (
+	sources sourceLocator
+
+	// mainModuleDir returns the directory and path of the main module.
+	mainModuleDir = sync.OnceValues(findMainModuleDir)
+)
+
+// locate returns the path on disk of the given source file, as reported by
+// runtime.Caller(). Binaries built with -trimpath report paths like
+// github.com/org/repo/pkg/file.go for the main module,
+// github.com/org/dep@v1.2.3/file.go for dependencies, and fmt/print.go for the
+// standard library. These are looked up in SourcePath, the main module's
+// directory, the module cache, GOPATH, and GOROOT, in that order. Absolute
+// paths that don't exist, because the binary was moved away from the machine
+// it was built on, are looked up in SourcePath. If the file can't be found,
+// locate returns it unchanged.
+func (s *sourceLocator) locate(file string) string {
+	if filepath.IsAbs(file) {
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if found, ok := s.found[file]; ok {
+		return found
+	}
+
+	for _, candidate := range sourceCandidates(file) {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			if s.found == nil {
+				s.found = map[string]string{}
+			}
+			s.found[file] = candidate
+
+			return candidate
+		}
+	}
+
+	// Don't remember misses. The file may be found once SourcePath is set.
+	return file
+}
+
+// sourceCandidates returns the paths on disk where the given trimmed or missing
+// absolute source file might be, most likely first.
+func sourceCandidates(file string) []string {
+	var candidates []string
+
+	// The search path may point at the root of a module or a GOPATH-style
+	// tree, so try every suffix of the path, longest first.
+	rel := file
+	if filepath.IsAbs(file) {
+		rel = strings.TrimLeft(filepath.ToSlash(file[len(filepath.VolumeName(file)):]), "/")
+	}
+	for _, dir := range searchPath() {
+		for suffix := rel; suffix != ""; {
+			candidates = append(candidates, filepath.Join(dir, filepath.FromSlash(suffix)))
+			_, suffix, _ = strings.Cut(suffix, "/")
+		}
+	}
+
+	if filepath.IsAbs(file) {
+		// The other places are only searched for trimmed paths, which are
+		// relative to them.
+		return candidates
+	}
+
+	if dir, modPath := mainModuleDir(); dir != "" {
+		if rel, ok := strings.CutPrefix(file, modPath+"/"); ok {
+			candidates = append(candidates, filepath.Join(dir, filepath.FromSlash(rel)))
+		}
+	}
+
+	if strings.Contains(file, "@") {
+		candidates = append(candidates, filepath.Join(moduleCacheDir(), filepath.FromSlash(escapeModulePath(file))))
+	}
+
+	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+		candidates = append(candidates, filepath.Join(gopath, "src", filepath.FromSlash(file)))
+	}
+
+	if build.Default.GOROOT != "" {
+		candidates = append(candidates, filepath.Join(build.Default.GOROOT, "src", filepath.FromSlash(file)))
+	}
+
+	return candidates
+}
+
+// searchPath returns the directories in SourcePath and $Q_SOURCE_PATH.
+func searchPath() []string {
+	dirs := append([]string(nil), SourcePath...)
+	for _, dir := range filepath.SplitList(os.Getenv(sourcePathEnv)) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// moduleCacheDir returns the directory of the module cache, the same way the go
+// command does.
+func moduleCacheDir() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+
+	gopaths := filepath.SplitList(build.Default.GOPATH)
+	if len(gopaths) == 0 {
+		return ""
+	}
+
+	return filepath.Join(gopaths[0], "pkg", "mod")
+}
+
+// escapeModulePath escapes the module path and version at the start of the
+// given file path the way the module cache does, by replacing each uppercase
+// letter with an exclamation mark followed by the lowercase letter, e.g.
+// github.com/BurntSushi/toml@v1.0.0/decode.go becomes
+// github.com/!burnt!sushi/toml@v1.0.0/decode.go. The path inside the module
+// isn't escaped.
+func escapeModulePath(file string) string {
+	at := strings.IndexByte(file, '@')
+	end := len(file)
+	if i := strings.IndexByte(file[at:], '/'); i >= 0 {
+		end = at + i
+	}
+
+	var b strings.Builder
+	for _, r := range file[:end] {
+		if 'A' <= r && r <= 'Z' {
+			b.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String() + file[end:]
+}
+
+// findMainModuleDir returns the directory and path of the main module. The
+// directory is found by looking for its go.mod file in the working directory
+// and its parents. It's empty if the binary wasn't built in module mode, or
+// it's run outside the module.
+func findMainModuleDir() (dir, modPath string) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Path == "" {
+		return "", ""
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", ""
+	}
+
+	for dir := wd; ; dir = filepath.Dir(dir) {
+		if modulePath(filepath.Join(dir, "go.mod")) == info.Main.Path {
+			return dir, info.Main.Path
+		}
+
+		if filepath.Dir(dir) == dir {
+			return "", ""
+		}
+	}
+}
+
+// modulePath returns the module path declared in the given go.mod file, or an
+// empty string if the file can't be read.
+func modulePath(gomod string) string {
+	f, err := os.Open(gomod)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "module" {
+			continue
+		}
+
+		if unquoted, err := strconv.Unquote(fields[1]); err == nil {
+			return unquoted
+		}
+
+		return fields[1]
+	}
+
+	return ""
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestLocateSource verifies that trimmed paths are found in each of the places
+// source files can be.
+func TestLocateSource(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modCache := t.TempDir()
+	t.Setenv("GOMODCACHE", modCache)
+	dep := filepath.Join(modCache, "github.com", "!burnt!sushi", "toml@v1.0.0", "decode.go")
+	writeFile(t, dep)
+
+	searchDir := t.TempDir()
+	searched := filepath.Join(searchDir, "pkg", "file.go")
+	writeFile(t, searched)
+	t.Setenv(sourcePathEnv, searchDir)
+
+	testCases := []struct {
+		file string
+		want string
+	}{
+		{file: filepath.Join(wd, "q.go"), want: filepath.Join(wd, "q.go")},
+		{file: "github.com/ryboe/q/testdata/sample1.go", want: filepath.Join(wd, "testdata", "sample1.go")},
+		{file: "github.com/BurntSushi/toml@v1.0.0/decode.go", want: dep},
+		{file: "github.com/me/repo/pkg/file.go", want: searched},
+		{file: filepath.Join(string(filepath.Separator), "build", "repo", "pkg", "file.go"), want: searched},
+		{file: filepath.Join(wd, "missing.go"), want: filepath.Join(wd, "missing.go")},
+		{file: "fmt/print.go", want: filepath.Join(build.Default.GOROOT, "src", "fmt", "print.go")},
+		{file: "github.com/me/repo/missing.go", want: "github.com/me/repo/missing.go"},
+	}
+
+	for _, tc := range testCases {
+		var l sourceLocator
+		got := l.locate(tc.file)
+		if got != tc.want {
+			t.Fatalf("\nlocate(%q)\ngot:  %q\nwant: %q", tc.file, got, tc.want)
+		}
+	}
+}
+
+// TestArgNamesTrimmedPath verifies that argNames() finds the source file of a
+// binary built with -trimpath.
+func TestArgNamesTrimmedPath(t *testing.T) {
+	const file = "github.com/ryboe/q/testdata/sample1.go"
+	want := []string{"a", "b", "c", "d", "e", "f", "g"}
+	got, err := argNames(caller{file: file, line: 14})
+	if err != nil || !slices.Equal(got, want) {
+		t.Fatalf("\nargNames(%q, 14)\ngot:  %q, %v\nwant: %q, nil", file, got, err, want)
+	}
+}
+
+// TestEscapeModulePath verifies that only the module path and version are
+// escaped.
+func TestEscapeModulePath(t *testing.T) {
+	testCases := []struct {
+		file string
+		want string
+	}{
+		{file: "github.com/ryboe/q@v1.0.0/q.go", want: "github.com/ryboe/q@v1.0.0/q.go"},
+		{file: "github.com/BurntSushi/toml@v1.0.0/Decode.go", want: "github.com/!burnt!sushi/toml@v1.0.0/Decode.go"},
+		{file: "example.com/m@v1.0.0-RC1/x.go", want: "example.com/m@v1.0.0-!r!c1/x.go"},
+	}
+
+	for _, tc := range testCases {
+		got := escapeModulePath(tc.file)
+		if got != tc.want {
+			t.Fatalf("\nescapeModulePath(%q)\ngot:  %q\nwant: %q", tc.file, got, tc.want)
+		}
+	}
+}
+
+// writeFile creates an empty file, and the directories containing it.
+func writeFile(t *testing.T, name string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// option is a special argument to Q() that changes what it logs. Options are
+// not printed themselves.
+type option int
+
+const (
+	// WithStack makes Q() print the stack trace of the calling goroutine after
+	// the other arguments, e.g. q.Q(x, q.WithStack).
+	WithStack option = iota + 1
+
+	// Expand makes Q() print well-known standard library types, like
+	// time.Time and *url.URL, as the structs they are, instead of in their
+	// human form, e.g. q.Q(t, q.Expand).
+	Expand
+)
+
+// maxStackDepth is the maximum number of frames captured in a stack trace.
+const maxStackDepth = 64
+
+// callers returns the program counters of the calling goroutine's stack,
+// starting at the user code that called the q function. Like getCallerInfo(),
+// it must be called directly by the exported q function.
+func callers() []uintptr {
+	pcs := make([]uintptr, maxStackDepth)
+	// +1 because, unlike runtime.Caller(), runtime.Callers() counts itself.
+	n := runtime.Callers(CallDepth+1, pcs)
+
+	return pcs[:n]
+}
+
+// formatStack returns the given stack trace, one frame per line, e.g.
+// "main.handle server/main.go:42". Frames in the runtime and q packages are
+// omitted.
+func formatStack(pcs []uintptr) string {
+	var lines []string
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if !isHiddenFrame(frame.Function, frame.File) {
+			lines = append(lines, formatFrame(frame.Function, frame.File, frame.Line))
+		}
+		if !more {
+			break
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// formatFrame returns a single colorized stack frame, e.g.
+// "main.handle server/main.go:42".
+func formatFrame(funcName, file string, line int) string {
+	return colorize(shortFunc(funcName), bold) + " " + colorize(shortFile(file)+":"+strconv.Itoa(line), cyan)
+}
+
+// isHiddenFrame returns true if the stack frame for the given function should
+// be left out of stack traces, because it's in the runtime or q packages.
+func isHiddenFrame(funcName, file string) bool {
+	if strings.HasPrefix(funcName, "runtime.") {
+		return true
+	}
+
+	// The q package's own tests call q functions, so they're not hidden.
+	return strings.HasPrefix(funcName, qPackagePath()+".") && !strings.HasSuffix(file, "_test.go")
+}
+
+// removeOptions returns the given values and their names without any options.
+func removeOptions(names []string, v []any) ([]string, []any) {
+	keptNames := make([]string, 0, len(names))
+	kept := make([]any, 0, len(v))
+	for i, value := range v {
+		if _, is := value.(option); is {
+			continue
+		}
+
+		kept = append(kept, value)
+		if i < len(names) {
+			keptNames = append(keptNames, names[i])
+		}
+	}
+
+	return keptNames, kept
+}
+
+// hasOption returns true if the given option is among the values.
+func hasOption(v []any, opt option) bool {
+	return slices.Contains(v, any(opt))
+}
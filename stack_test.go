@@ -0,0 +1,72 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// stackHelper calls Stack() one level below the test, so the test can check
+// that both frames are printed.
+func stackHelper() (file string, line int) {
+	_, file, line, _ = runtime.Caller(0)
+	Stack() // must be on the line after runtime.Caller()
+
+	return file, line + 1
+}
+
+// TestStack verifies that Stack() prints the calling goroutine's stack, without
+// frames from the runtime and q packages.
+func TestStack(t *testing.T) {
+	setTempDir(t)
+
+	file, line := stackHelper()
+
+	log := readLog(t)
+	if want := formatFrame("github.com/ryboe/q.stackHelper", file, line); !strings.Contains(log, want) {
+		t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+	}
+	if want := colorize("q.TestStack", bold); !strings.Contains(log, want) {
+		t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+	}
+
+	for _, hidden := range []string{"q.callers", "q.Stack", "runtime.goexit"} {
+		if strings.Contains(log, colorize(hidden, bold)) {
+			t.Fatalf("\nlog:  %q\nshould not contain: %q", log, hidden)
+		}
+	}
+}
+
+// TestQWithStack verifies that the WithStack option isn't printed as a value,
+// and doesn't misalign the names of the other arguments.
+func TestQWithStack(t *testing.T) {
+	setTempDir(t)
+
+	port := 443
+	Q(WithStack, port)
+
+	log := readLog(t)
+	if want := colorize("port", bold) + "=" + colorize("int(443)", cyan); !strings.Contains(log, want) {
+		t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+	}
+	if want := colorize("q.TestQWithStack", bold); !strings.Contains(log, want) {
+		t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+	}
+}
+
+// TestRemoveOptions verifies that removeOptions() removes options from the
+// values and their names.
+func TestRemoveOptions(t *testing.T) {
+	names, v := removeOptions([]string{"a", "q.WithStack", "b"}, []any{1, WithStack, 2})
+	if want := []string{"a", "b"}; !slices.Equal(names, want) {
+		t.Fatalf("\ngot:  %q\nwant: %q", names, want)
+	}
+	if want := []any{1, 2}; !slices.Equal(v, want) {
+		t.Fatalf("\ngot:  %v\nwant: %v", v, want)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayout is how time.Time values are printed. It's the layout of
+// time.Time.String(), without the monotonic clock reading.
+const timeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// humanTypes are the types that stdlibFormat knows the human form of.
+// nolint: gochecknoglobals
+var humanTypes = map[reflect.Type]bool{
+	reflect.TypeFor[time.Time]():       true,
+	reflect.TypeFor[time.Duration]():   true,
+	reflect.TypeFor[net.IP]():          true,
+	reflect.TypeFor[netip.Addr]():      true,
+	reflect.TypeFor[netip.AddrPort]():  true,
+	reflect.TypeFor[netip.Prefix]():    true,
+	reflect.TypeFor[url.URL]():         true,
+	reflect.TypeFor[big.Int]():         true,
+	reflect.TypeFor[big.Float]():       true,
+	reflect.TypeFor[big.Rat]():         true,
+	reflect.TypeFor[regexp.Regexp]():   true,
+	reflect.TypeFor[json.RawMessage](): true,
+	reflect.TypeFor[http.Header]():     true,
+}
+
+// stdlibFormat formats well-known standard library types in their human form,
+// e.g. time.Time as 2024-05-01 14:00:36 +0000 UTC instead of its wall, ext,
+// and loc fields. showType adds the type, e.g. time.Time(…). It returns false
+// for other types, and for values it can't read, like unexported fields that
+// aren't addressable.
+func stdlibFormat(v reflect.Value, showType bool) (string, bool) {
+	if !v.IsValid() || !humanTypes[v.Type()] {
+		return "", false
+	}
+
+	v, ok := exported(v)
+	if !ok {
+		return "", false
+	}
+
+	if h, ok := v.Interface().(http.Header); ok {
+		typ := ""
+		if showType {
+			typ = v.Type().String()
+		}
+
+		return typ + formatHeader(h), true
+	}
+
+	s, ok := humanForm(addressable(v).Addr().Interface())
+	if !ok {
+		return "", false
+	}
+
+	return inline(v, s, showType), true
+}
+
+// humanForm returns the human form of the value pointed to by x, if it's one
+// of the well-known types. Pointers are needed because some of the types only
+// have String methods with pointer receivers.
+// nolint: cyclop
+func humanForm(x any) (string, bool) {
+	switch x := x.(type) {
+	case *time.Time:
+		return x.Format(timeLayout), true
+	case *time.Duration:
+		return x.String(), true
+	case *net.IP:
+		if *x == nil {
+			return "", false
+		}
+
+		return x.String(), true
+	case *netip.Addr:
+		return x.String(), true
+	case *netip.AddrPort:
+		return x.String(), true
+	case *netip.Prefix:
+		return x.String(), true
+	case *url.URL:
+		// Don't print the password, if there is one.
+		return x.Redacted(), true
+	case *big.Int:
+		return x.String(), true
+	case *big.Float:
+		return x.Text('g', -1), true
+	case *big.Rat:
+		return x.RatString(), true
+	case *regexp.Regexp:
+		if strconv.CanBackquote(x.String()) {
+			return "`" + x.String() + "`", true
+		}
+
+		return strconv.Quote(x.String()), true
+	case *json.RawMessage:
+		return string(*x), true
+	}
+
+	return "", false
+}
+
+// formatHeader formats HTTP headers the way they're sent, with the values of
+// sensitive headers redacted, e.g.
+//
+//	{
+//	    Accept:        text/html,
+//	    Authorization: <redacted len=20>,
+//	}
+func formatHeader(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	entries := make([]entry, 0, len(keys))
+	for _, key := range keys {
+		value := strings.Join(h[key], ", ")
+		if redactions.matches(key) || isCredentialHeader(key) {
+			value = redacted(reflect.ValueOf(value))
+		}
+		entries = append(entries, entry{key: key + ":", value: value})
+	}
+
+	return layout("", entries, len(entries) > 1)
+}
+
+// isCredentialHeader returns true if the given header usually holds
+// credentials.
+func isCredentialHeader(key string) bool {
+	switch http.CanonicalHeaderKey(key) {
+	case "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,27 @@
+package main
+
+import "github.com/ryboe/q"
+
+func main() {
+	a, b, c, d := 1, 2, 3, 4
+
+	q.Q(
+		a,
+		b,
+	)
+
+	q.Q(a,
+		b)
+
+	q.Q(
+		foo(
+			c,
+		),
+		d,
+	)
+
+	q.Q(
+		q.V(c),
+		d,
+	)
+}
@@ -0,0 +1,12 @@
+package main
+
+import "github.com/ryboe/q"
+
+func main() {
+	a, b, c := 1, 2, 3
+	xs := []any{a, b}
+
+	q.Q(q.V(a))
+	_ = []int{q.V(b), q.V(c)}
+	q.Q(xs...)
+}
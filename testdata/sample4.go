@@ -0,0 +1,10 @@
+package main
+
+import dbg "github.com/ryboe/q"
+
+func Q(v ...any) int { return len(v) }
+
+func main() {
+	a, b := 1, 2
+	dbg.Q(a, Q(b))
+}
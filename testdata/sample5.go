@@ -0,0 +1,8 @@
+package main
+
+import . "github.com/ryboe/q"
+
+func main() {
+	a := 1
+	Q(a)
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// traceIndent is the indentation added for each level of nested q.Trace()
+// calls.
+const traceIndent = "  "
+
+// trace prints the entry line for a q.Trace() call and returns a function that
+// prints the exit line.
+func (l *logger) trace(c caller, callerErr error, v ...any) func() {
+	start := time.Now()
+	gid := goroutineID()
+	v = snapshotArgs(v)
+	name := colorize(shortFunc(c.funcName), bold)
+	indent := strings.Repeat(traceIndent, l.enterTrace(gid))
+
+	l.emit(c, callerErr, func(names []string) []string {
+		args := prependArgName(withTypes(names, typesOf(v)), formatArgs(v...))
+
+		return []string{indent + "→ " + name + "(" + strings.Join(args, ", ") + ")"}
+	})
+
+	return func() {
+		elapsed := roundDuration(time.Since(start))
+		l.exitTrace(gid)
+		l.emit(c, callerErr, func([]string) []string {
+			return []string{indent + "← " + name + " " + colorize(elapsed.String(), cyan)}
+		})
+	}
+}
+
+// enterTrace returns the number of active q.Trace() calls in the given
+// goroutine, and adds one.
+func (l *logger) enterTrace(gid int64) int {
+	l.traceMu.Lock()
+	defer l.traceMu.Unlock()
+
+	if l.traceDepth == nil {
+		l.traceDepth = map[int64]int{}
+	}
+	depth := l.traceDepth[gid]
+	l.traceDepth[gid]++
+
+	return depth
+}
+
+// exitTrace removes one of the active q.Trace() calls in the given goroutine.
+func (l *logger) exitTrace(gid int64) {
+	l.traceMu.Lock()
+	defer l.traceMu.Unlock()
+
+	if l.traceDepth[gid]--; l.traceDepth[gid] <= 0 {
+		delete(l.traceDepth, gid)
+	}
+}
+
+// goroutineID returns the ID of the current goroutine. The runtime doesn't
+// expose it, so it's parsed from the first line of the goroutine's stack
+// trace, which looks like "goroutine 18 [running]:".
+func goroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return id
+}
+
+// roundDuration rounds the given duration to 3 significant digits, e.g.
+// 12.345678ms becomes 12.3ms.
+func roundDuration(d time.Duration) time.Duration {
+	const sigDigits = 1000
+
+	r := time.Duration(1)
+	for d/r >= sigDigits {
+		r *= 10
+	}
+
+	return d.Round(r)
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRoundDuration verifies that roundDuration() rounds to 3 significant
+// digits.
+func TestRoundDuration(t *testing.T) {
+	testCases := []struct {
+		d    time.Duration
+		want time.Duration
+	}{
+		{d: 999 * time.Nanosecond, want: 999 * time.Nanosecond},
+		{d: 12345678 * time.Nanosecond, want: 12300 * time.Microsecond},
+		{d: 1500 * time.Millisecond, want: 1500 * time.Millisecond},
+		{d: 3*time.Minute + 7*time.Second + 321*time.Millisecond, want: 3*time.Minute + 7*time.Second},
+	}
+
+	for _, tc := range testCases {
+		if got := roundDuration(tc.d); got != tc.want {
+			t.Fatalf("\nroundDuration(%s)\ngot:  %s\nwant: %s", tc.d, got, tc.want)
+		}
+	}
+}
+
+// TestGoroutineID verifies that goroutineID() returns different IDs for
+// different goroutines.
+func TestGoroutineID(t *testing.T) {
+	id := goroutineID()
+	if id == 0 {
+		t.Fatal("goroutineID() failed to parse the goroutine ID")
+	}
+
+	other := make(chan int64)
+	go func() { other <- goroutineID() }()
+	if otherID := <-other; otherID == id {
+		t.Fatalf("\ngoroutineID() returned %d for two different goroutines", id)
+	}
+}
+
+// traceOuter and traceInner are used to test nested q.Trace() calls.
+func traceOuter(n int) {
+	defer Trace(n)()
+	traceInner(n + 1)
+}
+
+func traceInner(m int) {
+	defer Trace(m)()
+}
+
+// TestTrace verifies that Trace() prints indented entry and exit lines for
+// nested calls.
+func TestTrace(t *testing.T) {
+	setTempDir(t)
+
+	traceOuter(1)
+
+	log := readLog(t)
+	wants := []string{
+		"→ " + colorize("q.traceOuter", bold) + "(" + colorize("n", bold) + "=" + colorize("int(1)", cyan) + ")",
+		traceIndent + "→ " + colorize("q.traceInner", bold) + "(" + colorize("m", bold) + "=" + colorize("int(2)", cyan) + ")",
+		traceIndent + "← " + colorize("q.traceInner", bold) + " ",
+		" ← " + colorize("q.traceOuter", bold) + " ",
+	}
+	for _, want := range wants {
+		if !strings.Contains(log, want) {
+			t.Fatalf("\nlog:  %q\nmissing: %q", log, want)
+		}
+	}
+
+	if len(std.traceDepth) != 0 {
+		t.Fatalf("\nstd.traceDepth should be empty after all traced calls return\ngot:  %v", std.traceDepth)
+	}
+}